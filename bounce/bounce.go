@@ -0,0 +1,394 @@
+package bounce
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	ActionFailed    = "failed"
+	ActionDelayed   = "delayed"
+	ActionDelivered = "delivered"
+	ActionRelayed   = "relayed"
+	ActionExpanded  = "expanded"
+)
+
+// Bounce is the delivery status of a recipient reported by a bounce message.
+type Bounce struct {
+	Recipient  string `json:"recipient"`
+	Action     string `json:"action"`
+	Status     string `json:"status"`
+	Diagnostic string `json:"diagnostic"`
+	RemoteMTA  string `json:"remote_mta"`
+	EnvelopeID string `json:"envelope_id"`
+	MessageID  string `json:"message_id"`
+}
+
+// Markers of the plain text bounces of MTAs not sending delivery status
+// reports, matched in lower case across line breaks.
+var textMarkers = []string{
+	"this is the qmail-send program",
+	"could not be delivered to one or more of its recipients",
+	"i'm sorry to have to inform you that your message could not",
+	"the following addresses had permanent fatal errors",
+	"delivery to the following recipients failed",
+	"delivery has failed to these recipients or groups",
+}
+
+var (
+	basicStatus    = regexp.MustCompile(`\b([245])\d\d\b`)
+	enhancedStatus = regexp.MustCompile(`\b([245]\.\d{1,3}\.\d{1,3})\b`)
+	messageID      = regexp.MustCompile(`(?im)^message-id:[ \t]*(<[^>\r\n]+>)`)
+	textRecipient  = regexp.MustCompile(`^\s*<?([^\s<>@]+@[^\s<>@]+?)>?:?(\s+.*)?$`)
+)
+
+// ParseFile parses a bounce message file, or all the messages of a file in
+// mbox format.
+func ParseFile(name string) ([]Bounce, error) {
+	buf, err := os.ReadFile(name)
+	if err != nil {
+		return nil, errors.Wrap(err, "read failed")
+	}
+
+	if bytes.HasPrefix(buf, []byte("From ")) {
+		return ParseMbox(bytes.NewReader(buf))
+	}
+
+	return Parse(bytes.NewReader(buf))
+}
+
+// ParseMbox parses the messages of an mbox, skipping those that are not
+// bounces or cannot be parsed, so that one malformed message does not hide the
+// bounces of the others.
+func ParseMbox(r io.Reader) ([]Bounce, error) {
+	var bounces []Bounce
+	var msg bytes.Buffer
+	var found, blank bool
+
+	flush := func() {
+		if !found {
+			return
+		}
+		if buf, err := Parse(&msg); err == nil {
+			bounces = append(bounces, buf...)
+		}
+		msg.Reset()
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	for first := true; scanner.Scan(); first = false {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "From ") && (first || blank) {
+			flush()
+			found, blank = true, false
+			continue
+		}
+		if strings.HasPrefix(line, ">") && strings.HasPrefix(strings.TrimLeft(line, ">"), "From ") {
+			line = line[1:]
+		}
+		blank = line == ""
+		msg.WriteString(line + "\n")
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "read failed")
+	}
+
+	flush()
+
+	return bounces, nil
+}
+
+// Parse parses a bounce message. Delivery status reports (RFC 3464) are read
+// field by field, and the plain text bounces of common MTAs line by line. A
+// message that is not a bounce has no bounces.
+func Parse(r io.Reader) ([]Bounce, error) {
+	msg, err := mail.ReadMessage(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse failed")
+	}
+
+	var rep report
+
+	if err := rep.walk(textproto.MIMEHeader(msg.Header), msg.Body); err != nil {
+		return nil, err
+	}
+
+	return rep.bounces(), nil
+}
+
+// Correlate returns the bounces of the messages with the given Message-IDs.
+func Correlate(bounces []Bounce, ids []string) []Bounce {
+	sent := map[string]bool{}
+	for _, item := range ids {
+		if id := normalizeID(item); id != "" {
+			sent[id] = true
+		}
+	}
+
+	buf := []Bounce{}
+	for _, item := range bounces {
+		if sent[normalizeID(item.MessageID)] {
+			buf = append(buf, item)
+		}
+	}
+
+	return buf
+}
+
+// Failed returns the bounces of failed or delayed deliveries, leaving out the
+// successful deliveries that DSNs report as well.
+func Failed(bounces []Bounce) []Bounce {
+	buf := []Bounce{}
+	for _, item := range bounces {
+		if item.Action == ActionFailed || item.Action == ActionDelayed {
+			buf = append(buf, item)
+		}
+	}
+
+	return buf
+}
+
+func normalizeID(id string) string {
+	return strings.Trim(strings.TrimSpace(id), "<>")
+}
+
+type report struct {
+	recipients []Bounce
+	envelopeID string
+	messageID  string
+	text       strings.Builder
+}
+
+func (r *report) walk(header textproto.MIMEHeader, body io.Reader) error {
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		mediaType = "text/plain"
+	}
+
+	switch strings.ToLower(header.Get("Content-Transfer-Encoding")) {
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	}
+
+	switch {
+	case strings.HasPrefix(mediaType, "multipart/"):
+		mr := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := mr.NextPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return errors.Wrap(err, "multipart failed")
+			}
+			if err := r.walk(part.Header, part); err != nil {
+				return err
+			}
+		}
+	case mediaType == "message/delivery-status", mediaType == "message/global-delivery-status":
+		return r.parseStatus(body)
+	case mediaType == "message/rfc822", mediaType == "message/global",
+		mediaType == "text/rfc822-headers", mediaType == "message/global-headers":
+		return r.parseHeaders(body)
+	case mediaType == "text/plain":
+		buf, err := io.ReadAll(body)
+		if err != nil {
+			return errors.Wrap(err, "read failed")
+		}
+		r.text.Write(buf)
+		r.text.WriteString("\n")
+	}
+
+	return nil
+}
+
+// parseStatus reads the per-message fields of a delivery status report, then
+// the fields of each recipient.
+func (r *report) parseStatus(body io.Reader) error {
+	tp := textproto.NewReader(bufio.NewReader(body))
+
+	first := true
+
+	for {
+		fields, err := tp.ReadMIMEHeader()
+		if err != nil && err != io.EOF {
+			return errors.Wrap(err, "delivery status failed")
+		}
+
+		switch recipient := typedValue(fields.Get("Final-Recipient")); {
+		case len(fields) == 0:
+			// Blocks may be separated by several blank lines
+		case first:
+			r.envelopeID = fields.Get("Original-Envelope-Id")
+			first = false
+		case recipient != "":
+			b := Bounce{
+				Recipient:  recipient,
+				Action:     strings.ToLower(strings.TrimSpace(fields.Get("Action"))),
+				Diagnostic: typedValue(fields.Get("Diagnostic-Code")),
+				RemoteMTA:  typedValue(fields.Get("Remote-MTA")),
+			}
+			if buf := strings.Fields(fields.Get("Status")); len(buf) != 0 {
+				b.Status = buf[0]
+			} else {
+				b.Status = status(b.Diagnostic, b.Action)
+			}
+			r.recipients = append(r.recipients, b)
+		}
+
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
+
+// parseHeaders reads the Message-ID of the original message.
+func (r *report) parseHeaders(body io.Reader) error {
+	header, err := textproto.NewReader(bufio.NewReader(body)).ReadMIMEHeader()
+	if err != nil && err != io.EOF {
+		return errors.Wrap(err, "original message failed")
+	}
+
+	if r.messageID == "" {
+		r.messageID = strings.TrimSpace(header.Get("Message-Id"))
+	}
+
+	return nil
+}
+
+func (r *report) bounces() []Bounce {
+	if len(r.recipients) == 0 {
+		r.parseText(r.text.String())
+	}
+
+	for i := range r.recipients {
+		r.recipients[i].EnvelopeID = r.envelopeID
+		r.recipients[i].MessageID = r.messageID
+	}
+
+	return r.recipients
+}
+
+// parseText reads plain text bounces, in which each failed recipient is on a
+// line of its own, possibly followed by the reason of the failure, and the
+// original message is quoted after a separator.
+func (r *report) parseText(text string) {
+	lower := strings.Join(strings.Fields(strings.ToLower(text)), " ")
+
+	found := false
+	for _, item := range textMarkers {
+		if strings.Contains(lower, item) {
+			found = true
+			break
+		}
+	}
+
+	if !found {
+		return
+	}
+
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+
+	var current *Bounce
+	var diagnostic []string
+
+	end := func() {
+		if current != nil {
+			current.Diagnostic = strings.Join(diagnostic, " ")
+			current.Status = status(current.Diagnostic, ActionFailed)
+			r.recipients = append(r.recipients, *current)
+		}
+		current, diagnostic = nil, nil
+	}
+
+	i := 0
+	for ; i < len(lines); i++ {
+		line := strings.TrimSpace(lines[i])
+		if isCopySeparator(line) {
+			break
+		}
+		if line == "" {
+			end()
+			continue
+		}
+		if match := textRecipient.FindStringSubmatch(lines[i]); match != nil && !strings.Contains(match[1], ":") {
+			end()
+			current = &Bounce{Recipient: match[1], Action: ActionFailed}
+			if rest := strings.TrimSpace(match[2]); rest != "" {
+				diagnostic = append(diagnostic, rest)
+			}
+			continue
+		}
+		if current != nil {
+			diagnostic = append(diagnostic, line)
+		}
+	}
+
+	end()
+
+	if r.messageID == "" {
+		if match := messageID.FindStringSubmatch(strings.Join(lines[i:], "\n")); match != nil {
+			r.messageID = match[1]
+		}
+	}
+}
+
+func isCopySeparator(line string) bool {
+	lower := strings.ToLower(line)
+
+	if strings.HasPrefix(lower, "---") && (strings.Contains(lower, "copy") || strings.Contains(lower, "original message")) {
+		return true
+	}
+
+	return strings.HasPrefix(lower, "return-path:") || strings.HasPrefix(lower, "received:")
+}
+
+// status returns the enhanced status code (RFC 3463) given in a diagnostic, or
+// derives it from the basic reply code or the action.
+func status(diagnostic, action string) string {
+	if match := enhancedStatus.FindStringSubmatch(diagnostic); match != nil {
+		return match[1]
+	}
+
+	if match := basicStatus.FindStringSubmatch(diagnostic); match != nil {
+		return match[1] + ".0.0"
+	}
+
+	switch action {
+	case ActionFailed:
+		return "5.0.0"
+	case ActionDelayed:
+		return "4.0.0"
+	case ActionDelivered, ActionRelayed, ActionExpanded:
+		return "2.0.0"
+	}
+
+	return ""
+}
+
+// typedValue returns the value of a field such as "rfc822; alen@example.com"
+// without its type.
+func typedValue(field string) string {
+	if i := strings.Index(field, ";"); i != -1 {
+		field = field[i+1:]
+	}
+
+	return strings.TrimSpace(field)
+}
@@ -0,0 +1,219 @@
+package bounce
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const reportMessage = `From: Mail Delivery System <MAILER-DAEMON@mx.example.com>
+To: mail@example.com
+Subject: Undelivered Mail Returned to Sender
+MIME-Version: 1.0
+Content-Type: multipart/report; report-type=delivery-status; boundary="B"
+
+--B
+Content-Type: text/plain
+
+I'm sorry to have to inform you that your message could not
+be delivered to one or more recipients.
+
+--B
+Content-Type: message/delivery-status
+
+Reporting-MTA: dns; mx.example.com
+Original-Envelope-Id: notice-42
+
+Final-Recipient: rfc822; alen@example.com
+Original-Recipient: rfc822;alen@example.com
+Action: failed
+Status: 5.1.1
+Remote-MTA: dns; mx.example.org
+Diagnostic-Code: smtp; 550 5.1.1 <alen@example.com>: Recipient address
+    rejected: User unknown
+
+Final-Recipient: rfc822; bob@example.com
+Action: delayed
+Diagnostic-Code: smtp; 451 Try again later
+
+--B
+Content-Type: text/rfc822-headers
+
+From: mail@example.com
+To: alen@example.com, bob@example.com
+Message-ID: <123.456@example.com>
+Subject: Notice
+
+--B--
+`
+
+const qmailMessage = `From: MAILER-DAEMON@example.com
+To: mail@example.com
+Subject: failure notice
+
+Hi. This is the qmail-send program at example.com.
+I'm afraid I wasn't able to deliver your message to the following addresses.
+This is a permanent error; I've given up. Sorry it didn't work out.
+
+<alen@example.com>:
+Sorry, no mailbox here by that name. (#5.1.1)
+
+<bob@example.com>:
+192.0.2.1 does not like recipient.
+Remote host said: 552 Mailbox full
+
+--- Below this line is a copy of the message.
+
+Return-Path: <mail@example.com>
+Message-ID: <789@example.com>
+Subject: Notice
+`
+
+const eximMessage = `From: Mail Delivery System <Mailer-Daemon@example.com>
+To: mail@example.com
+Subject: Mail delivery failed: returning message to sender
+Content-Type: text/plain; charset=us-ascii
+Content-Transfer-Encoding: quoted-printable
+
+This message was created automatically by mail delivery software.
+
+A message that you sent could not be delivered to one or more of its
+recipients. This is a permanent error. The following address(es) failed:
+
+  alen@example.com
+    host mx.example.org [192.0.2.1]
+    SMTP error from remote mail server after RCPT TO:<alen@example.com>:
+    550 5.7.1 Relaying denied
+
+------ This is a copy of the message, including all the headers. ------
+
+Message-Id: <abc@example.com>
+Subject: Notice
+`
+
+func TestParse(t *testing.T) {
+	buf, err := Parse(strings.NewReader(reportMessage))
+	if err != nil || len(buf) != 2 {
+		t.Fatal("FAIL")
+	}
+
+	if buf[0].Recipient != "alen@example.com" || buf[0].Action != ActionFailed || buf[0].Status != "5.1.1" {
+		t.Error("FAIL")
+	}
+
+	if buf[0].Diagnostic != "550 5.1.1 <alen@example.com>: Recipient address rejected: User unknown" {
+		t.Error("FAIL")
+	}
+
+	if buf[0].RemoteMTA != "mx.example.org" || buf[0].EnvelopeID != "notice-42" || buf[0].MessageID != "<123.456@example.com>" {
+		t.Error("FAIL")
+	}
+
+	if buf[1].Recipient != "bob@example.com" || buf[1].Action != ActionDelayed || buf[1].Status != "4.0.0" {
+		t.Error("FAIL")
+	}
+
+	if buf, err := Parse(strings.NewReader("From: alen@example.com\nSubject: Hello\n\nHello\n")); err != nil || len(buf) != 0 {
+		t.Error("FAIL")
+	}
+
+	if _, err := Parse(strings.NewReader("")); err == nil {
+		t.Error("FAIL")
+	}
+}
+
+func TestParseText(t *testing.T) {
+	buf, err := Parse(strings.NewReader(qmailMessage))
+	if err != nil || len(buf) != 2 {
+		t.Fatal("FAIL")
+	}
+
+	if buf[0].Recipient != "alen@example.com" || buf[0].Status != "5.1.1" || buf[0].Action != ActionFailed {
+		t.Error("FAIL")
+	}
+
+	if buf[1].Recipient != "bob@example.com" || buf[1].Status != "5.0.0" || buf[1].MessageID != "<789@example.com>" {
+		t.Error("FAIL")
+	}
+
+	if buf[1].Diagnostic != "192.0.2.1 does not like recipient. Remote host said: 552 Mailbox full" {
+		t.Error("FAIL")
+	}
+
+	buf, err = Parse(strings.NewReader(eximMessage))
+	if err != nil || len(buf) != 1 {
+		t.Fatal("FAIL")
+	}
+
+	if buf[0].Recipient != "alen@example.com" || buf[0].Status != "5.7.1" || buf[0].MessageID != "<abc@example.com>" {
+		t.Error("FAIL")
+	}
+}
+
+func TestParseFile(t *testing.T) {
+	dir := t.TempDir()
+
+	name := filepath.Join(dir, "bounce.eml")
+	if err := os.WriteFile(name, []byte(reportMessage), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if buf, err := ParseFile(name); err != nil || len(buf) != 2 {
+		t.Error("FAIL")
+	}
+
+	mbox := "From MAILER-DAEMON Mon Jan  2 15:04:05 2006\n" + qmailMessage + "\n" +
+		"From alen@example.com Mon Jan  2 15:04:05 2006\nFrom: alen@example.com\nSubject: Hello\n\n>From here\n\n" +
+		"From bob@example.com Mon Jan  2 15:04:05 2006\nmalformed header\n\nbody\n\n" +
+		"From MAILER-DAEMON Mon Jan  2 15:04:05 2006\n" + eximMessage
+
+	name = filepath.Join(dir, "bounces.mbox")
+	if err := os.WriteFile(name, []byte(mbox), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if buf, err := ParseFile(name); err != nil || len(buf) != 3 {
+		t.Error("FAIL")
+	}
+
+	if _, err := ParseFile(filepath.Join(dir, "invalid")); err == nil {
+		t.Error("FAIL")
+	}
+}
+
+func TestCorrelate(t *testing.T) {
+	bounces := []Bounce{
+		{Recipient: "alen@example.com", MessageID: "<123@example.com>"},
+		{Recipient: "bob@example.com", MessageID: "<456@example.com>"},
+		{Recipient: "carol@example.com"},
+	}
+
+	buf := Correlate(bounces, []string{"123@example.com", " <789@example.com> ", ""})
+	if len(buf) != 1 || buf[0].Recipient != "alen@example.com" {
+		t.Error("FAIL")
+	}
+
+	if buf := Correlate(bounces, nil); buf == nil || len(buf) != 0 {
+		t.Error("FAIL")
+	}
+}
+
+func TestFailed(t *testing.T) {
+	bounces := []Bounce{
+		{Recipient: "alen@example.com", Action: ActionFailed},
+		{Recipient: "bob@example.com", Action: ActionDelivered},
+		{Recipient: "carol@example.com", Action: ActionDelayed},
+		{Recipient: "david@example.com", Action: ActionRelayed},
+		{Recipient: "eve@example.com", Action: ActionExpanded},
+	}
+
+	buf := Failed(bounces)
+	if len(buf) != 2 || buf[0].Recipient != "alen@example.com" || buf[1].Recipient != "carol@example.com" {
+		t.Error("FAIL")
+	}
+
+	if buf := Failed(nil); buf == nil || len(buf) != 0 {
+		t.Error("FAIL")
+	}
+}
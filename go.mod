@@ -3,16 +3,23 @@ module github.com/craftslab/gomail
 go 1.24.3
 
 require (
+	github.com/ProtonMail/go-crypto v1.1.6
 	github.com/go-ldap/ldap/v3 v3.1.7
 	github.com/go-mail/mail v2.3.1+incompatible
 	github.com/pkg/errors v0.8.1
+	github.com/yuin/goldmark v1.8.6
 	gopkg.in/alecthomas/kingpin.v2 v2.2.6
 )
 
 require (
 	github.com/alecthomas/template v0.0.0-20190718012654-fb15b899a751 // indirect
 	github.com/alecthomas/units v0.0.0-20190924025748-f65c72e2690d // indirect
+	github.com/cloudflare/circl v1.3.7 // indirect
 	github.com/go-asn1-ber/asn1-ber v1.3.1 // indirect
+	go.mozilla.org/pkcs7 v0.9.0 // indirect
+	golang.org/x/crypto v0.17.0 // indirect
+	golang.org/x/sys v0.16.0 // indirect
+	golang.org/x/text v0.14.0 // indirect
 	gopkg.in/alexcesaro/quotedprintable.v3 v3.0.0-20150716171945-2caba252f4dc // indirect
 )
 
@@ -0,0 +1,198 @@
+package mail
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/mail"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Methods of calendar events (RFC 5546).
+const (
+	// CalendarRequest invites the attendees to an event, or updates it.
+	CalendarRequest = "REQUEST"
+	// CalendarCancel cancels an event.
+	CalendarCancel = "CANCEL"
+)
+
+// calendarLayout is the UTC date-time format of iCalendar.
+const calendarLayout = "20060102T150405Z"
+
+// An Event is an iCalendar (RFC 5545) event sent as a meeting invitation.
+type Event struct {
+	// UID identifies the event. Updates and cancellations of an event must keep
+	// its UID and increase its Sequence.
+	UID      string
+	Sequence int
+	// Method is CalendarRequest or CalendarCancel. It defaults to
+	// CalendarRequest.
+	Method      string
+	Summary     string
+	Description string
+	Location    string
+	Start       time.Time
+	End         time.Time
+	// Organizer, Attendees and OptionalAttendees are addresses such as
+	// "Alen <alen@example.com>". Attendees are asked to reply.
+	Organizer         string
+	Attendees         []string
+	OptionalAttendees []string
+	// Stamp is the time the invitation was created. It defaults to the current
+	// time.
+	Stamp time.Time
+}
+
+// AddCalendar adds the event as a text/calendar alternative part, which mail
+// clients show as an invitation. It is usually added after the plain text or
+// HTML body describing the event.
+func (m *Message) AddCalendar(e *Event, settings ...PartSetting) {
+	m.AddAlternativeWriter("text/calendar; method="+e.method(), func(w io.Writer) error {
+		_, err := e.WriteTo(w)
+		return err
+	}, settings...)
+}
+
+func (e *Event) method() string {
+	if e.Method == "" {
+		return CalendarRequest
+	}
+
+	return strings.ToUpper(e.Method)
+}
+
+// WriteTo implements io.WriterTo. It writes the event as an iCalendar object,
+// which can also be saved as an .ics file.
+func (e *Event) WriteTo(w io.Writer) (int64, error) {
+	if e.UID == "" {
+		return 0, errors.New("gomail: calendar event UID is empty")
+	}
+	if e.Start.IsZero() || e.End.Before(e.Start) {
+		return 0, errors.New("gomail: invalid calendar event start or end")
+	}
+
+	method := e.method()
+	status := "CONFIRMED"
+	switch method {
+	case CalendarRequest:
+	case CalendarCancel:
+		status = "CANCELLED"
+	default:
+		return 0, fmt.Errorf("gomail: invalid calendar method %q", e.Method)
+	}
+
+	stamp := e.Stamp
+	if stamp.IsZero() {
+		stamp = now()
+	}
+
+	cw := &calendarWriter{}
+	cw.line("BEGIN:VCALENDAR")
+	cw.line("PRODID:-//go-mail//gomail//EN")
+	cw.line("VERSION:2.0")
+	cw.line("CALSCALE:GREGORIAN")
+	cw.line("METHOD:" + method)
+	cw.line("BEGIN:VEVENT")
+	cw.line("UID:" + calendarText(e.UID))
+	cw.line(fmt.Sprintf("SEQUENCE:%d", e.Sequence))
+	cw.line("DTSTAMP:" + stamp.UTC().Format(calendarLayout))
+	cw.line("DTSTART:" + e.Start.UTC().Format(calendarLayout))
+	cw.line("DTEND:" + e.End.UTC().Format(calendarLayout))
+	cw.line("SUMMARY:" + calendarText(e.Summary))
+	if e.Description != "" {
+		cw.line("DESCRIPTION:" + calendarText(e.Description))
+	}
+	if e.Location != "" {
+		cw.line("LOCATION:" + calendarText(e.Location))
+	}
+	if e.Organizer != "" {
+		cw.address("ORGANIZER", "", e.Organizer)
+	}
+	for _, addr := range e.Attendees {
+		cw.address("ATTENDEE", ";ROLE=REQ-PARTICIPANT;PARTSTAT=NEEDS-ACTION;RSVP=TRUE", addr)
+	}
+	for _, addr := range e.OptionalAttendees {
+		cw.address("ATTENDEE", ";ROLE=OPT-PARTICIPANT;PARTSTAT=NEEDS-ACTION;RSVP=TRUE", addr)
+	}
+	cw.line("STATUS:" + status)
+	cw.line("END:VEVENT")
+	cw.line("END:VCALENDAR")
+
+	if cw.err != nil {
+		return 0, cw.err
+	}
+
+	n, err := w.Write(cw.buf.Bytes())
+	return int64(n), err
+}
+
+// calendarWriter writes the content lines of an iCalendar object, folded at 75
+// octets.
+type calendarWriter struct {
+	buf bytes.Buffer
+	err error
+}
+
+func (cw *calendarWriter) line(s string) {
+	limit := 75
+	for len(s) > limit {
+		// Do not split UTF-8 sequences.
+		i := limit
+		for !utf8.RuneStart(s[i]) {
+			i--
+		}
+		cw.buf.WriteString(s[:i] + "\r\n ")
+		s = s[i:]
+		// Continuation lines start with a space.
+		limit = 74
+	}
+	cw.buf.WriteString(s + "\r\n")
+}
+
+func (cw *calendarWriter) address(name, params, addr string) {
+	a, err := mail.ParseAddress(addr)
+	if err != nil {
+		if cw.err == nil {
+			cw.err = fmt.Errorf("gomail: invalid calendar address %q: %v", addr, err)
+		}
+		return
+	}
+
+	if a.Name != "" {
+		params = ";CN=" + calendarParam(a.Name) + params
+	}
+
+	cw.line(name + params + ":mailto:" + a.Address)
+}
+
+// calendarText escapes a TEXT value.
+func calendarText(s string) string {
+	return strings.NewReplacer(
+		`\`, `\\`,
+		";", `\;`,
+		",", `\,`,
+		"\r\n", `\n`,
+		"\n", `\n`,
+		"\r", "",
+	).Replace(s)
+}
+
+// calendarParam quotes a parameter value if needed. Double quotes and control
+// characters are not allowed in parameter values and are dropped.
+func calendarParam(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if r == '"' || r < ' ' || r == 0x7f {
+			return -1
+		}
+		return r
+	}, s)
+
+	if strings.ContainsAny(s, ":;,") {
+		return `"` + s + `"`
+	}
+
+	return s
+}
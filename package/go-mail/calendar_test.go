@@ -0,0 +1,131 @@
+package mail
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestCalendar(t *testing.T) {
+	m := NewMessage()
+	m.SetHeader("From", "from@example.com")
+	m.SetHeader("To", "to@example.com")
+	m.SetBody("text/plain", "Maintenance")
+	m.AddCalendar(&Event{
+		UID:       "42@example.com",
+		Summary:   "Maintenance",
+		Start:     time.Date(2014, 06, 26, 20, 0, 0, 0, time.UTC),
+		End:       time.Date(2014, 06, 26, 22, 0, 0, 0, time.UTC),
+		Organizer: "from@example.com",
+		Attendees: []string{"To <to@example.com>"},
+	}, SetPartEncoding(Unencoded))
+
+	want := &message{
+		from: "from@example.com",
+		to:   []string{"to@example.com"},
+		content: "From: from@example.com\r\n" +
+			"To: to@example.com\r\n" +
+			"Content-Type: multipart/alternative;\r\n" +
+			" boundary=_BOUNDARY_1_\r\n" +
+			"\r\n" +
+			"--_BOUNDARY_1_\r\n" +
+			"Content-Type: text/plain; charset=UTF-8\r\n" +
+			"Content-Transfer-Encoding: quoted-printable\r\n" +
+			"\r\n" +
+			"Maintenance\r\n" +
+			"--_BOUNDARY_1_\r\n" +
+			"Content-Type: text/calendar; method=REQUEST; charset=UTF-8\r\n" +
+			"Content-Transfer-Encoding: 8bit\r\n" +
+			"\r\n" +
+			"BEGIN:VCALENDAR\r\n" +
+			"PRODID:-//go-mail//gomail//EN\r\n" +
+			"VERSION:2.0\r\n" +
+			"CALSCALE:GREGORIAN\r\n" +
+			"METHOD:REQUEST\r\n" +
+			"BEGIN:VEVENT\r\n" +
+			"UID:42@example.com\r\n" +
+			"SEQUENCE:0\r\n" +
+			"DTSTAMP:20140625T174600Z\r\n" +
+			"DTSTART:20140626T200000Z\r\n" +
+			"DTEND:20140626T220000Z\r\n" +
+			"SUMMARY:Maintenance\r\n" +
+			"ORGANIZER:mailto:from@example.com\r\n" +
+			"ATTENDEE;CN=To;ROLE=REQ-PARTICIPANT;PARTSTAT=NEEDS-ACTION;RSVP=TRUE:mailto:\r\n" +
+			" to@example.com\r\n" +
+			"STATUS:CONFIRMED\r\n" +
+			"END:VEVENT\r\n" +
+			"END:VCALENDAR\r\n" +
+			"\r\n" +
+			"--_BOUNDARY_1_--\r\n",
+	}
+
+	testMessage(t, m, 1, want)
+}
+
+func TestEventWriteTo(t *testing.T) {
+	start := time.Date(2014, 06, 26, 22, 0, 0, 0, time.FixedZone("CEST", 2*60*60))
+	e := &Event{
+		UID:               "42@example.com",
+		Sequence:          1,
+		Method:            "cancel",
+		Summary:           "Maintenance; database, cache",
+		Description:       "Line 1\nLine 2 \\ " + strings.Repeat("é", 40),
+		Location:          "Room 1",
+		Start:             start,
+		End:               start.Add(time.Hour),
+		Organizer:         `"Ops: Team" <ops@example.com>`,
+		OptionalAttendees: []string{"cc@example.com"},
+	}
+
+	var buf bytes.Buffer
+	if _, err := e.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	got := buf.String()
+	unfolded := strings.Replace(got, "\r\n ", "", -1)
+
+	for _, want := range []string{
+		"METHOD:CANCEL\r\n",
+		"SEQUENCE:1\r\n",
+		"DTSTART:20140626T200000Z\r\n",
+		"DTEND:20140626T210000Z\r\n",
+		`SUMMARY:Maintenance\; database\, cache` + "\r\n",
+		"LOCATION:Room 1\r\n",
+		`ORGANIZER;CN="Ops: Team":mailto:ops@example.com` + "\r\n",
+		"ATTENDEE;ROLE=OPT-PARTICIPANT;PARTSTAT=NEEDS-ACTION;RSVP=TRUE:mailto:cc@example.com\r\n",
+		"STATUS:CANCELLED\r\n",
+	} {
+		if !strings.Contains(unfolded, want) {
+			t.Errorf("Missing %q in %q", want, unfolded)
+		}
+	}
+
+	// Long lines are folded without splitting characters.
+	if want := `DESCRIPTION:Line 1\nLine 2 \\ ` + strings.Repeat("é", 40) + "\r\n"; !strings.Contains(unfolded, want) {
+		t.Errorf("Invalid description in %q", got)
+	}
+	for _, line := range strings.Split(got, "\r\n") {
+		if len(line) > 75 || !utf8.ValidString(line) {
+			t.Errorf("Invalid folded line %q", line)
+		}
+	}
+}
+
+func TestEventError(t *testing.T) {
+	start := time.Date(2014, 06, 26, 20, 0, 0, 0, time.UTC)
+	tests := []*Event{
+		{Start: start, End: start},
+		{UID: "42@example.com", End: start},
+		{UID: "42@example.com", Start: start, End: start.Add(-time.Hour)},
+		{UID: "42@example.com", Start: start, End: start, Method: "PUBLISH"},
+		{UID: "42@example.com", Start: start, End: start, Attendees: []string{"invalid"}},
+	}
+
+	for _, e := range tests {
+		if _, err := e.WriteTo(&bytes.Buffer{}); err == nil || !strings.HasPrefix(err.Error(), "gomail: ") {
+			t.Errorf("WriteTo() error for %+v, got %v", e, err)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// A DirectorySender writes emails as .eml files to a directory, such as the
+// pickup directory of a local MTA.
+//
+// Each file is written under a temporary name and then renamed, so that the
+// MTA never picks up a partial message.
+type DirectorySender struct {
+	// Dir is the directory the files are written to.
+	Dir string
+	// Envelope prepends X-Sender and X-Receiver fields holding the envelope
+	// to each file, as read by IIS-style pickup directories. Otherwise the MTA
+	// derives the envelope from the message and Bcc recipients are lost.
+	Envelope bool
+}
+
+// Send implements Sender.
+func (s *DirectorySender) Send(from string, to []string, msg io.WriterTo) error {
+	return s.SendContext(context.Background(), from, to, msg)
+}
+
+// SendContext implements ContextSender.
+func (s *DirectorySender) SendContext(ctx context.Context, from string, to []string, msg io.WriterTo) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+
+	if s.Envelope {
+		buf.WriteString("X-Sender: <" + from + ">\r\n")
+		for _, addr := range to {
+			buf.WriteString("X-Receiver: <" + addr + ">\r\n")
+		}
+	}
+
+	if _, err := msg.WriteTo(&buf); err != nil {
+		return err
+	}
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+	name := strconv.FormatInt(now().UnixNano(), 10) + "." + hex.EncodeToString(id) + ".eml"
+
+	tmp := filepath.Join(s.Dir, "."+name+".tmp")
+	if err := os.WriteFile(tmp, buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("gomail: could not write message: %v", err)
+	}
+
+	if err := os.Rename(tmp, filepath.Join(s.Dir, name)); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("gomail: could not write message: %v", err)
+	}
+
+	return nil
+}
+
+// Close implements SendCloser. It does nothing.
+func (s *DirectorySender) Close() error {
+	return nil
+}
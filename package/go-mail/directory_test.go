@@ -0,0 +1,74 @@
+package mail
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDirectorySender(t *testing.T) {
+	dir := t.TempDir()
+	s := &DirectorySender{Dir: dir}
+
+	if err := Send(s, getTestMessage(), getTestMessage()); err != nil {
+		t.Fatalf("Send(): %v", err)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 || files[0] == files[1] {
+		t.Fatalf("Invalid files %q", files)
+	}
+
+	for _, name := range files {
+		if filepath.Ext(name) != ".eml" {
+			t.Errorf("Invalid file name %q", name)
+		}
+		msg, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		compareBodies(t, string(msg), testMsg)
+	}
+}
+
+func TestDirectorySenderEnvelope(t *testing.T) {
+	dir := t.TempDir()
+	s := &DirectorySender{Dir: dir, Envelope: true}
+
+	m := getTestMessage()
+	m.SetHeader("Bcc", "bcc@example.com")
+
+	if err := Send(s, m); err != nil {
+		t.Fatalf("Send(): %v", err)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("Invalid files %q: %v", files, err)
+	}
+
+	msg, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "X-Sender: <" + testFrom + ">\r\n" +
+		"X-Receiver: <" + testTo1 + ">\r\n" +
+		"X-Receiver: <" + testTo2 + ">\r\n" +
+		"X-Receiver: <bcc@example.com>\r\n"
+	if !strings.HasPrefix(string(msg), want) {
+		t.Errorf("Invalid envelope in %q", msg)
+	}
+	if strings.Contains(string(msg), "Bcc:") {
+		t.Error("Bcc header should not be written")
+	}
+
+	s.Dir = filepath.Join(dir, "invalid")
+	if err := Send(s, getTestMessage()); err == nil {
+		t.Error("Send() should fail with an invalid directory")
+	}
+}
@@ -0,0 +1,342 @@
+package mail
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Canonicalization represents a DKIM canonicalization algorithm as defined in
+// RFC 6376, section 3.4.
+type Canonicalization string
+
+const (
+	// CanonicalizationSimple tolerates almost no modification of the message.
+	CanonicalizationSimple Canonicalization = "simple"
+	// CanonicalizationRelaxed tolerates common modifications such as
+	// whitespace replacement and header field line rewrapping.
+	CanonicalizationRelaxed Canonicalization = "relaxed"
+)
+
+// DefaultDKIMHeaders is the list of header fields signed when
+// DKIMOptions.Headers is empty. Fields absent from the message are skipped.
+var DefaultDKIMHeaders = []string{
+	"From", "Sender", "Reply-To", "Subject", "Date", "Message-ID", "To", "Cc",
+	"In-Reply-To", "References", "MIME-Version", "Content-Type",
+	"Content-Transfer-Encoding",
+}
+
+// DKIMOptions configures a DKIMSigner.
+type DKIMOptions struct {
+	// Domain is the signing domain (d= tag).
+	Domain string
+	// Selector is the selector subdividing the key namespace (s= tag).
+	Selector string
+	// Signer is the private key, either an *rsa.PrivateKey or an
+	// ed25519.PrivateKey.
+	Signer crypto.Signer
+	// HeaderCanonicalization defaults to CanonicalizationRelaxed.
+	HeaderCanonicalization Canonicalization
+	// BodyCanonicalization defaults to CanonicalizationRelaxed.
+	BodyCanonicalization Canonicalization
+	// Headers is the list of header fields to sign. From is always signed.
+	Headers []string
+}
+
+// A DKIMSigner signs messages with a DKIM-Signature header field as defined in
+// RFC 6376 (rsa-sha256) and RFC 8463 (ed25519-sha256).
+type DKIMSigner struct {
+	options   DKIMOptions
+	algorithm string
+}
+
+// NewDKIMSigner returns a new DKIMSigner.
+func NewDKIMSigner(options DKIMOptions) (*DKIMSigner, error) {
+	if options.Domain == "" || options.Selector == "" {
+		return nil, errors.New("gomail: DKIM domain and selector are required")
+	}
+
+	s := &DKIMSigner{options: options}
+
+	switch options.Signer.(type) {
+	case *rsa.PrivateKey:
+		s.algorithm = "rsa-sha256"
+	case ed25519.PrivateKey:
+		s.algorithm = "ed25519-sha256"
+	default:
+		return nil, fmt.Errorf("gomail: unsupported DKIM key type %T", options.Signer)
+	}
+
+	for _, c := range []*Canonicalization{&s.options.HeaderCanonicalization, &s.options.BodyCanonicalization} {
+		switch *c {
+		case "":
+			*c = CanonicalizationRelaxed
+		case CanonicalizationSimple, CanonicalizationRelaxed:
+		default:
+			return nil, fmt.Errorf("gomail: unsupported DKIM canonicalization %q", *c)
+		}
+	}
+
+	if len(s.options.Headers) == 0 {
+		s.options.Headers = DefaultDKIMHeaders
+	}
+
+	return s, nil
+}
+
+// ParseDKIMPrivateKey parses a PEM encoded RSA (PKCS #1 or PKCS #8) or
+// Ed25519 (PKCS #8) private key.
+func ParseDKIMPrivateKey(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("gomail: no PEM data found in DKIM private key")
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("gomail: invalid DKIM private key: %v", err)
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("gomail: unsupported DKIM key type %T", key)
+	}
+
+	return signer, nil
+}
+
+// SetDKIM is a message setting to sign the email with the given DKIMSigner
+// when it is written.
+func SetDKIM(s *DKIMSigner) MessageSetting {
+	return func(m *Message) {
+		m.dkim = s
+	}
+}
+
+// Sign writes msg to w with a DKIM-Signature header field prepended. Bare LF
+// line endings are converted to CRLF before signing.
+func (s *DKIMSigner) Sign(w io.Writer, msg []byte) (int64, error) {
+	msg = toCRLF(msg)
+
+	field, err := s.signature(msg)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := io.WriteString(w, field)
+	if err != nil {
+		return int64(n), err
+	}
+
+	m, err := w.Write(msg)
+
+	return int64(n + m), err
+}
+
+func (s *DKIMSigner) signature(msg []byte) (string, error) {
+	header, body := splitMessage(msg)
+	fields := parseHeaderFields(header)
+
+	bh := sha256.Sum256(canonicalBody(body, s.options.BodyCanonicalization))
+
+	var names []string
+	var signed [][]byte
+	used := make(map[int]bool)
+
+	for _, h := range append([]string{"From"}, s.options.Headers...) {
+		// Pick instances from the bottom up as required by RFC 6376, 5.4.2.
+		for i := len(fields) - 1; i >= 0; i-- {
+			if !used[i] && strings.EqualFold(fieldName(fields[i]), h) {
+				used[i] = true
+				names = append(names, strings.ToLower(h))
+				signed = append(signed, fields[i])
+				break
+			}
+		}
+	}
+
+	if len(names) == 0 || names[0] != "from" {
+		return "", errors.New(`gomail: cannot DKIM sign message, "From" field is absent`)
+	}
+
+	tags := []string{
+		"v=1",
+		"a=" + s.algorithm,
+		"c=" + string(s.options.HeaderCanonicalization) + "/" + string(s.options.BodyCanonicalization),
+		"d=" + s.options.Domain,
+		"s=" + s.options.Selector,
+		"t=" + strconv.FormatInt(now().Unix(), 10),
+		"h=" + strings.Join(names, ":"),
+		"bh=" + base64.StdEncoding.EncodeToString(bh[:]),
+	}
+
+	field := "DKIM-Signature: " + strings.Join(tags, ";\r\n\t") + ";\r\n\tb="
+
+	hash := sha256.New()
+	for _, f := range signed {
+		hash.Write(canonicalHeader(f, s.options.HeaderCanonicalization))
+	}
+	hash.Write(bytes.TrimSuffix(canonicalHeader([]byte(field+"\r\n"), s.options.HeaderCanonicalization), []byte("\r\n")))
+
+	var sig []byte
+	var err error
+	if s.algorithm == "ed25519-sha256" {
+		sig, err = s.options.Signer.Sign(rand.Reader, hash.Sum(nil), crypto.Hash(0))
+	} else {
+		sig, err = s.options.Signer.Sign(rand.Reader, hash.Sum(nil), crypto.SHA256)
+	}
+	if err != nil {
+		return "", fmt.Errorf("gomail: DKIM signing failed: %v", err)
+	}
+
+	b := base64.StdEncoding.EncodeToString(sig)
+	for len(b) > maxLineLen-4 {
+		field += b[:maxLineLen-4] + "\r\n\t"
+		b = b[maxLineLen-4:]
+	}
+
+	return field + b + "\r\n", nil
+}
+
+// splitMessage splits a message into its header, including the CRLF ending the
+// last field, and its body.
+func splitMessage(msg []byte) ([]byte, []byte) {
+	if bytes.HasPrefix(msg, []byte("\r\n")) {
+		return nil, msg[2:]
+	}
+
+	if i := bytes.Index(msg, []byte("\r\n\r\n")); i != -1 {
+		return msg[:i+2], msg[i+4:]
+	}
+
+	return msg, nil
+}
+
+// parseHeaderFields splits a header into raw fields, keeping continuation lines
+// and the trailing CRLF of each field.
+func parseHeaderFields(header []byte) [][]byte {
+	var fields [][]byte
+
+	for len(header) > 0 {
+		end := 0
+		for {
+			i := bytes.Index(header[end:], []byte("\r\n"))
+			if i == -1 {
+				end = len(header)
+				break
+			}
+			end += i + 2
+			if end >= len(header) || (header[end] != ' ' && header[end] != '\t') {
+				break
+			}
+		}
+		fields = append(fields, header[:end])
+		header = header[end:]
+	}
+
+	return fields
+}
+
+func fieldName(field []byte) string {
+	if i := bytes.IndexByte(field, ':'); i != -1 {
+		return strings.TrimRight(string(field[:i]), " \t")
+	}
+
+	return ""
+}
+
+func canonicalHeader(field []byte, c Canonicalization) []byte {
+	if c == CanonicalizationSimple {
+		return field
+	}
+
+	i := bytes.IndexByte(field, ':')
+	if i == -1 {
+		return field
+	}
+
+	name := strings.ToLower(strings.TrimRight(string(field[:i]), " \t"))
+	value := strings.ReplaceAll(string(field[i+1:]), "\r\n", "")
+	value = strings.TrimSpace(collapseWSP(value))
+
+	return []byte(name + ":" + value + "\r\n")
+}
+
+func canonicalBody(body []byte, c Canonicalization) []byte {
+	if c == CanonicalizationRelaxed {
+		lines := strings.Split(string(body), "\r\n")
+		for i, l := range lines {
+			lines[i] = strings.TrimRight(collapseWSP(l), " ")
+		}
+		body = []byte(strings.Join(lines, "\r\n"))
+	}
+
+	for bytes.HasSuffix(body, []byte("\r\n")) {
+		body = body[:len(body)-2]
+	}
+
+	if len(body) == 0 {
+		if c == CanonicalizationRelaxed {
+			return nil
+		}
+		return []byte("\r\n")
+	}
+
+	return append(body, '\r', '\n')
+}
+
+func collapseWSP(s string) string {
+	var b strings.Builder
+	wsp := false
+
+	for i := 0; i < len(s); i++ {
+		if s[i] == ' ' || s[i] == '\t' {
+			wsp = true
+			continue
+		}
+		if wsp {
+			b.WriteByte(' ')
+			wsp = false
+		}
+		b.WriteByte(s[i])
+	}
+
+	if wsp {
+		b.WriteByte(' ')
+	}
+
+	return b.String()
+}
+
+func toCRLF(msg []byte) []byte {
+	if !bytes.Contains(msg, []byte("\n")) {
+		return msg
+	}
+
+	var buf bytes.Buffer
+	buf.Grow(len(msg))
+
+	for i, c := range msg {
+		if c == '\n' && (i == 0 || msg[i-1] != '\r') {
+			buf.WriteByte('\r')
+		}
+		buf.WriteByte(c)
+	}
+
+	return buf.Bytes()
+}
@@ -0,0 +1,294 @@
+package mail
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestDKIMCanonicalization(t *testing.T) {
+	// RFC 6376, section 3.4.6
+	header := []byte("A: X\r\nB : Y\t\r\n\tZ  \r\n")
+	body := []byte(" C \r\nD \t E\r\n\r\n\r\n")
+
+	var got []byte
+	for _, f := range parseHeaderFields(header) {
+		got = append(got, canonicalHeader(f, CanonicalizationRelaxed)...)
+	}
+	if want := "a:X\r\nb:Y Z\r\n"; string(got) != want {
+		t.Errorf("Invalid relaxed header, got %q, want %q", got, want)
+	}
+
+	got = nil
+	for _, f := range parseHeaderFields(header) {
+		got = append(got, canonicalHeader(f, CanonicalizationSimple)...)
+	}
+	if !bytes.Equal(got, header) {
+		t.Errorf("Invalid simple header, got %q, want %q", got, header)
+	}
+
+	if got, want := canonicalBody(body, CanonicalizationRelaxed), " C\r\nD E\r\n"; string(got) != want {
+		t.Errorf("Invalid relaxed body, got %q, want %q", got, want)
+	}
+
+	if got, want := canonicalBody(body, CanonicalizationSimple), " C \r\nD \t E\r\n"; string(got) != want {
+		t.Errorf("Invalid simple body, got %q, want %q", got, want)
+	}
+
+	if got := canonicalBody(nil, CanonicalizationRelaxed); len(got) != 0 {
+		t.Errorf("Invalid relaxed empty body, got %q", got)
+	}
+
+	if got, want := canonicalBody([]byte("\r\n\r\n"), CanonicalizationSimple), "\r\n"; string(got) != want {
+		t.Errorf("Invalid simple empty body, got %q, want %q", got, want)
+	}
+}
+
+func TestDKIMSign(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	canons := []Canonicalization{CanonicalizationSimple, CanonicalizationRelaxed}
+
+	for _, key := range []crypto.Signer{rsaKey, edKey} {
+		for _, hc := range canons {
+			for _, bc := range canons {
+				name := fmt.Sprintf("%T/%s/%s", key, hc, bc)
+
+				s, err := NewDKIMSigner(DKIMOptions{
+					Domain:                 "example.com",
+					Selector:               "mail",
+					Signer:                 key,
+					HeaderCanonicalization: hc,
+					BodyCanonicalization:   bc,
+				})
+				if err != nil {
+					t.Fatalf("%s: NewDKIMSigner(): %v", name, err)
+				}
+
+				m := NewMessage(SetDKIM(s))
+				m.SetAddressHeader("From", "from@example.com", "Señor From")
+				m.SetHeader("To", "to@example.com")
+				m.SetHeader("Subject", "¡Hola, señor! "+strings.Repeat("long subject ", 10))
+				m.SetBody("text/plain", "¡Hola, señor!\n\ttrailing  \n\n")
+				m.AddAlternative("text/html", "<p>¡Hola, señor!</p>")
+				m.Attach(mockCopyFile("/tmp/test.pdf"))
+
+				var buf bytes.Buffer
+				n, err := m.WriteTo(&buf)
+				if err != nil {
+					t.Fatalf("%s: WriteTo(): %v", name, err)
+				}
+				if n != int64(buf.Len()) {
+					t.Errorf("%s: invalid length, got %d, want %d", name, n, buf.Len())
+				}
+				if !strings.HasPrefix(buf.String(), "DKIM-Signature: v=1;") {
+					t.Errorf("%s: message does not start with DKIM-Signature", name)
+				}
+
+				if err := verifyDKIM(buf.Bytes(), key.Public()); err != nil {
+					t.Errorf("%s: verifyDKIM(): %v", name, err)
+				}
+
+				tampered := bytes.Replace(buf.Bytes(), []byte("Subject: "), []byte("Subject: Re: "), 1)
+				if err := verifyDKIM(tampered, key.Public()); err == nil {
+					t.Errorf("%s: verifyDKIM() should fail on a tampered header", name)
+				}
+
+				tampered = append(buf.Bytes()[:buf.Len():buf.Len()], []byte("extra\r\n")...)
+				if err := verifyDKIM(tampered, key.Public()); err == nil {
+					t.Errorf("%s: verifyDKIM() should fail on a tampered body", name)
+				}
+			}
+		}
+	}
+}
+
+func TestDKIMSignHeaders(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := NewDKIMSigner(DKIMOptions{
+		Domain:   "example.com",
+		Selector: "mail",
+		Signer:   key,
+		Headers:  []string{"Subject", "X-Build-Id", "X-Build-Id"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := "From: from@example.com\r\nX-Build-Id: 1\r\nSubject: test\r\nX-Build-Id: 2\r\n\r\nbody\r\n"
+
+	var buf bytes.Buffer
+	if _, err := s.Sign(&buf, []byte(msg)); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(buf.String(), "h=from:subject:x-build-id:x-build-id;") {
+		t.Errorf("Invalid h= tag in %q", buf.String())
+	}
+
+	if err := verifyDKIM(buf.Bytes(), key.Public()); err != nil {
+		t.Errorf("verifyDKIM(): %v", err)
+	}
+
+	if _, err := s.Sign(&buf, []byte("To: to@example.com\r\n\r\nbody")); err == nil {
+		t.Error("Sign() should fail without a From field")
+	}
+}
+
+func TestNewDKIMSigner(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []DKIMOptions{
+		{Selector: "mail", Signer: key},
+		{Domain: "example.com", Signer: key},
+		{Domain: "example.com", Selector: "mail"},
+		{Domain: "example.com", Selector: "mail", Signer: key, BodyCanonicalization: "loose"},
+	}
+
+	for _, o := range tests {
+		if _, err := NewDKIMSigner(o); err == nil {
+			t.Errorf("NewDKIMSigner(%+v) should fail", o)
+		}
+	}
+}
+
+func TestParseDKIMPrivateKey(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pkcs1 := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)})
+	if key, err := ParseDKIMPrivateKey(pkcs1); err != nil {
+		t.Errorf("ParseDKIMPrivateKey(PKCS1): %v", err)
+	} else if _, ok := key.(*rsa.PrivateKey); !ok {
+		t.Errorf("Invalid key type %T", key)
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(edKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkcs8 := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	if key, err := ParseDKIMPrivateKey(pkcs8); err != nil {
+		t.Errorf("ParseDKIMPrivateKey(PKCS8): %v", err)
+	} else if _, ok := key.(ed25519.PrivateKey); !ok {
+		t.Errorf("Invalid key type %T", key)
+	}
+
+	if _, err := ParseDKIMPrivateKey([]byte("invalid")); err == nil {
+		t.Error("ParseDKIMPrivateKey() should fail on invalid data")
+	}
+
+	junk := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: []byte("junk")})
+	if _, err := ParseDKIMPrivateKey(junk); err == nil {
+		t.Error("ParseDKIMPrivateKey() should fail on invalid key")
+	}
+}
+
+var dkimSignatureTag = regexp.MustCompile(`(^|;)(\s*b\s*=)[^;]*`)
+
+// verifyDKIM verifies the first DKIM-Signature of msg as a receiver would.
+func verifyDKIM(msg []byte, pub crypto.PublicKey) error {
+	header, body := splitMessage(msg)
+	fields := parseHeaderFields(header)
+
+	if len(fields) == 0 || fieldName(fields[0]) != "DKIM-Signature" {
+		return errors.New("DKIM-Signature not found")
+	}
+	sigField := fields[0]
+	fields = fields[1:]
+
+	tags := make(map[string]string)
+	value := string(sigField[bytes.IndexByte(sigField, ':')+1:])
+	for _, tag := range strings.Split(value, ";") {
+		kv := strings.SplitN(tag, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		v := strings.NewReplacer(" ", "", "\t", "", "\r", "", "\n", "").Replace(kv[1])
+		tags[strings.TrimSpace(kv[0])] = v
+	}
+
+	canon := strings.SplitN(tags["c"], "/", 2)
+	hc, bc := Canonicalization(canon[0]), Canonicalization(canon[1])
+
+	bh := sha256.Sum256(canonicalBody(body, bc))
+	if base64.StdEncoding.EncodeToString(bh[:]) != tags["bh"] {
+		return errors.New("body hash mismatch")
+	}
+
+	hash := sha256.New()
+	used := make(map[int]bool)
+	for _, h := range strings.Split(tags["h"], ":") {
+		for i := len(fields) - 1; i >= 0; i-- {
+			if !used[i] && strings.EqualFold(fieldName(fields[i]), h) {
+				used[i] = true
+				hash.Write(canonicalHeader(fields[i], hc))
+				break
+			}
+		}
+	}
+
+	name := sigField[:bytes.IndexByte(sigField, ':')+1]
+	stripped := dkimSignatureTag.ReplaceAll(sigField[len(name):], []byte("$1$2"))
+	stripped = append(append([]byte{}, name...), stripped...)
+	if !bytes.HasSuffix(stripped, []byte("\r\n")) {
+		stripped = append(stripped, '\r', '\n')
+	}
+	hash.Write(bytes.TrimSuffix(canonicalHeader(stripped, hc), []byte("\r\n")))
+
+	sig, err := base64.StdEncoding.DecodeString(tags["b"])
+	if err != nil {
+		return err
+	}
+
+	switch key := pub.(type) {
+	case *rsa.PublicKey:
+		if tags["a"] != "rsa-sha256" {
+			return errors.New("algorithm mismatch")
+		}
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, hash.Sum(nil), sig)
+	case ed25519.PublicKey:
+		if tags["a"] != "ed25519-sha256" {
+			return errors.New("algorithm mismatch")
+		}
+		if !ed25519.Verify(key, hash.Sum(nil), sig) {
+			return errors.New("signature mismatch")
+		}
+		return nil
+	}
+
+	return fmt.Errorf("unsupported key type %T", pub)
+}
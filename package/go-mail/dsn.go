@@ -0,0 +1,116 @@
+package mail
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Values of DSN.Return.
+const (
+	// DSNReturnFull returns the full message in failure notifications.
+	DSNReturnFull = "FULL"
+	// DSNReturnHeaders returns only the headers of the message in failure
+	// notifications.
+	DSNReturnHeaders = "HDRS"
+)
+
+// Values of DSN.Notify.
+const (
+	DSNNotifySuccess = "SUCCESS"
+	DSNNotifyFailure = "FAILURE"
+	DSNNotifyDelay   = "DELAY"
+	DSNNotifyNever   = "NEVER"
+)
+
+// DSN requests delivery status notifications for a message (RFC 3461). The
+// parameters are only sent if the SMTP server supports the DSN extension.
+type DSN struct {
+	// Return is DSNReturnFull or DSNReturnHeaders. If empty, the server
+	// decides what failure notifications contain.
+	Return string
+	// EnvelopeID is an identifier of the transaction included in the
+	// notifications, to match them with the message.
+	EnvelopeID string
+	// Notify lists the conditions under which notifications are sent:
+	// DSNNotifySuccess, DSNNotifyFailure and DSNNotifyDelay, or DSNNotifyNever
+	// alone. If empty, the server decides, usually on failure and delay.
+	Notify []string
+	// OriginalRecipient adds the address of each recipient as its original
+	// recipient (ORCPT), which is reported in the notifications even if the
+	// message is forwarded.
+	OriginalRecipient bool
+}
+
+// SetDSN is a message setting to request delivery status notifications for
+// the email.
+func SetDSN(d *DSN) MessageSetting {
+	return func(m *Message) {
+		m.dsn = d
+	}
+}
+
+// mailParams returns the DSN parameters of the MAIL command.
+func (d *DSN) mailParams() ([]string, error) {
+	var params []string
+
+	switch ret := strings.ToUpper(d.Return); ret {
+	case "":
+	case DSNReturnFull, DSNReturnHeaders:
+		params = append(params, "RET="+ret)
+	default:
+		return nil, fmt.Errorf("gomail: invalid DSN return %q", d.Return)
+	}
+
+	if d.EnvelopeID != "" {
+		params = append(params, "ENVID="+xtext(d.EnvelopeID))
+	}
+
+	return params, nil
+}
+
+// rcptParams returns the DSN parameters of the RCPT command of addr.
+func (d *DSN) rcptParams(addr string) ([]string, error) {
+	var params []string
+
+	if len(d.Notify) != 0 {
+		notify := make([]string, len(d.Notify))
+		for i, n := range d.Notify {
+			notify[i] = strings.ToUpper(n)
+			switch notify[i] {
+			case DSNNotifySuccess, DSNNotifyFailure, DSNNotifyDelay:
+			case DSNNotifyNever:
+				if len(d.Notify) != 1 {
+					return nil, errors.New("gomail: invalid DSN notify, NEVER must be alone")
+				}
+			default:
+				return nil, fmt.Errorf("gomail: invalid DSN notify %q", n)
+			}
+		}
+		params = append(params, "NOTIFY="+strings.Join(notify, ","))
+	}
+
+	if d.OriginalRecipient {
+		params = append(params, "ORCPT=rfc822;"+xtext(addr))
+	}
+
+	return params, nil
+}
+
+// xtext encodes s as defined in RFC 3461, section 4: "+", "=" and characters
+// outside of "!" to "~" are written as "+" followed by their hexadecimal
+// value.
+func xtext(s string) string {
+	var b strings.Builder
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c < '!' || c > '~' || c == '+' || c == '=' {
+			fmt.Fprintf(&b, "+%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+
+	return b.String()
+}
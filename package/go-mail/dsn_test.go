@@ -0,0 +1,74 @@
+package mail
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSendDSN(t *testing.T) {
+	dsn := &DSN{
+		Return:            DSNReturnHeaders,
+		EnvelopeID:        "notice 42+1",
+		Notify:            []string{DSNNotifySuccess, "failure"},
+		OriginalRecipient: true,
+	}
+
+	l := listenTest(t, "tcp", "127.0.0.1:0")
+	done := serveESMTP(l, []string{"PIPELINING", "DSN", "SMTPUTF8"}, nil)
+
+	m := getTestMessage()
+	m.applySettings([]MessageSetting{SetDSN(dsn)})
+
+	if err := testNetworkDialer(l).DialAndSend(m); err != nil {
+		t.Fatalf("DialAndSend(): %v", err)
+	}
+
+	want := []string{
+		"EHLO localhost",
+		"MAIL FROM:<" + testFrom + "> RET=HDRS ENVID=notice+2042+2B1",
+		"RCPT TO:<" + testTo1 + "> NOTIFY=SUCCESS,FAILURE ORCPT=rfc822;" + testTo1,
+		"RCPT TO:<" + testTo2 + "> NOTIFY=SUCCESS,FAILURE ORCPT=rfc822;" + testTo2,
+		"DATA",
+		"QUIT",
+	}
+	if s := <-done; !reflect.DeepEqual(s.cmds, want) {
+		t.Errorf("Invalid commands, got %q, want %q", s.cmds, want)
+	}
+
+	// The parameters are not sent if the server does not support DSN.
+	l = listenTest(t, "tcp", "127.0.0.1:0")
+	done = serveESMTP(l, nil, nil)
+
+	if err := testNetworkDialer(l).DialAndSend(m); err != nil {
+		t.Fatalf("DialAndSend(): %v", err)
+	}
+
+	if s := <-done; s.cmds[1] != "MAIL FROM:<"+testFrom+">" || s.cmds[2] != "RCPT TO:<"+testTo1+">" {
+		t.Errorf("Invalid commands %q", s.cmds)
+	}
+}
+
+func TestDSNError(t *testing.T) {
+	tests := []*DSN{
+		{Return: "BODY"},
+		{Notify: []string{"SOMETIMES"}},
+		{Notify: []string{DSNNotifyNever, DSNNotifyFailure}},
+	}
+
+	for _, dsn := range tests {
+		_, err := dsn.mailParams()
+		if err == nil {
+			_, err = dsn.rcptParams(testTo1)
+		}
+		if err == nil || !strings.HasPrefix(err.Error(), "gomail: invalid DSN") {
+			t.Errorf("Invalid error for %+v, got %v", dsn, err)
+		}
+	}
+}
+
+func TestXText(t *testing.T) {
+	if got, want := xtext("a+b=c d\x7f"), "a+2Bb+3Dc+20d+7F"; got != want {
+		t.Errorf("xtext() = %q, want %q", got, want)
+	}
+}
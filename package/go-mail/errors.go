@@ -1,6 +1,9 @@
 package mail
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+)
 
 // A SendError represents the failure to transmit a Message, detailing the cause
 // of the failure and index of the Message within a batch.
@@ -14,3 +17,36 @@ func (err *SendError) Error() string {
 	return fmt.Sprintf("gomail: could not send email %d: %v",
 		err.Index+1, err.Cause)
 }
+
+// Unwrap returns the cause of the failure.
+func (err *SendError) Unwrap() error {
+	return err.Cause
+}
+
+// A RecipientStatus is the reply of the server to a single recipient.
+type RecipientStatus struct {
+	Address string
+	Code    int
+	Message string
+}
+
+// String returns the status as "address: code message".
+func (s RecipientStatus) String() string {
+	return fmt.Sprintf("%s: %d %s", s.Address, s.Code, s.Message)
+}
+
+// A RecipientError is returned by an LMTP sender when the server rejects some
+// recipients of a message, either at RCPT or after the data. Unless every
+// recipient is rejected, the message was delivered to the others.
+type RecipientError struct {
+	Rejected []RecipientStatus
+}
+
+func (err *RecipientError) Error() string {
+	list := make([]string, len(err.Rejected))
+	for i, s := range err.Rejected {
+		list[i] = s.String()
+	}
+
+	return "gomail: recipients rejected: " + strings.Join(list, "; ")
+}
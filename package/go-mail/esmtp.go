@@ -0,0 +1,149 @@
+package mail
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+)
+
+// smtpExtensions are the ESMTP extensions advertised by the server that the
+// sender makes use of.
+type smtpExtensions struct {
+	pipelining   bool
+	chunking     bool
+	eightBitMIME bool
+	binaryMIME   bool
+	dsn          bool
+	// size is the maximum message size, 0 if there is none, or -1 if the
+	// server does not advertise SIZE.
+	size int64
+}
+
+// extensions returns the connection of the client and the extensions of the
+// server. The connection is nil if the client does not give access to it, in
+// which case no extension is used.
+func (c *smtpSender) extensions() (*textproto.Conn, smtpExtensions) {
+	ext := smtpExtensions{size: -1}
+
+	tc, ok := c.smtpClient.(textClient)
+	if !ok {
+		return nil, ext
+	}
+
+	ext.pipelining, _ = c.Extension("PIPELINING")
+	ext.chunking, _ = c.Extension("CHUNKING")
+	ext.eightBitMIME, _ = c.Extension("8BITMIME")
+	ext.binaryMIME, _ = c.Extension("BINARYMIME")
+	ext.dsn, _ = c.Extension("DSN")
+
+	if ok, param := c.Extension("SIZE"); ok {
+		ext.size = 0
+		if n, err := strconv.ParseInt(param, 10, 64); err == nil && n > 0 {
+			ext.size = n
+		}
+	}
+
+	return tc.textConn(), ext
+}
+
+// envelopeParams are the ESMTP parameters of the MAIL command and of the RCPT
+// command of each recipient.
+type envelopeParams struct {
+	mail []string
+	rcpt map[string][]string
+	// binary is set with BODY=BINARYMIME, in which case the message must be
+	// sent unchanged.
+	binary bool
+}
+
+// prepare renders msg for the extensions of the server and returns it with
+// the parameters of the envelope of the recipients to.
+//
+// A Message uses the 8bit transfer encoding for its text parts with 8BITMIME,
+// and the binary one for its files with BINARYMIME and CHUNKING, since BDAT is
+// the only way to send binary data. With SIZE, the message is rendered up front
+// so that a message over the limit of the server is rejected before being
+// sent. With DSN, the notifications requested by a Message are passed on.
+func (c *smtpSender) prepare(ext smtpExtensions, msg io.WriterTo, to []string) (io.WriterTo, envelopeParams, error) {
+	var params envelopeParams
+
+	m, ok := msg.(*Message)
+	binary := ok && ext.binaryMIME && ext.chunking && m.dkim == nil
+
+	if ok && (ext.eightBitMIME || binary) {
+		msg = writerToFunc(func(w io.Writer) (int64, error) {
+			return m.writeTo(w, true, binary)
+		})
+	}
+
+	if binary {
+		params.binary = true
+		params.mail = append(params.mail, "BODY=BINARYMIME")
+	} else if ext.eightBitMIME {
+		params.mail = append(params.mail, "BODY=8BITMIME")
+	}
+
+	if ok && ext.dsn && m.dsn != nil {
+		mail, err := m.dsn.mailParams()
+		if err != nil {
+			return nil, params, err
+		}
+		params.mail = append(params.mail, mail...)
+
+		params.rcpt = make(map[string][]string)
+		for _, addr := range to {
+			if params.rcpt[addr], err = m.dsn.rcptParams(addr); err != nil {
+				return nil, params, err
+			}
+		}
+	}
+
+	if ext.size >= 0 {
+		var buf bytes.Buffer
+		if _, err := msg.WriteTo(&buf); err != nil {
+			return nil, params, err
+		}
+
+		if ext.size > 0 && int64(buf.Len()) > ext.size {
+			return nil, params, fmt.Errorf("gomail: message size of %d bytes exceeds the server limit of %d bytes", buf.Len(), ext.size)
+		}
+
+		params.mail = append(params.mail, "SIZE="+strconv.Itoa(buf.Len()))
+		rendered := buf.Bytes()
+		msg = writerToFunc(func(w io.Writer) (int64, error) {
+			n, err := w.Write(rendered)
+			return int64(n), err
+		})
+	}
+
+	return msg, params, nil
+}
+
+type writerToFunc func(w io.Writer) (int64, error)
+
+func (f writerToFunc) WriteTo(w io.Writer) (int64, error) {
+	return f(w)
+}
+
+// fits8bit reports whether a CRLF terminated body can be sent with the 8bit
+// transfer encoding, that is without NUL, bare CR or lines longer than 998
+// octets (RFC 2045, 2.8).
+func fits8bit(b []byte) bool {
+	for len(b) > 0 {
+		line := b
+		i := bytes.Index(b, []byte("\r\n"))
+		if i != -1 {
+			line, b = b[:i], b[i+2:]
+		} else {
+			b = nil
+		}
+
+		if len(line) > 998 || bytes.IndexByte(line, 0) != -1 || bytes.IndexByte(line, '\r') != -1 {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,184 @@
+package mail
+
+import (
+	"io"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestSendSize(t *testing.T) {
+	l := listenTest(t, "tcp", "127.0.0.1:0")
+	done := serveESMTP(l, []string{"SIZE 100"}, nil)
+
+	err := testNetworkDialer(l).DialAndSend(getTestMessage())
+	if err == nil || !strings.Contains(err.Error(), "exceeds the server limit of 100 bytes") {
+		t.Errorf("DialAndSend() error, got %v", err)
+	}
+
+	// The message is rejected before the transaction starts.
+	if s := <-done; len(s.cmds) != 2 || s.cmds[1] != "QUIT" {
+		t.Errorf("Invalid commands %q", s.cmds)
+	}
+
+	l = listenTest(t, "tcp", "127.0.0.1:0")
+	done = serveESMTP(l, []string{"SIZE 10000"}, nil)
+
+	if err := testNetworkDialer(l).DialAndSend(getTestMessage()); err != nil {
+		t.Fatalf("DialAndSend(): %v", err)
+	}
+
+	want := "MAIL FROM:<" + testFrom + "> SIZE=" + strconv.Itoa(len(testMsg))
+	if s := <-done; s.cmds[1] != want {
+		t.Errorf("Invalid MAIL command, got %q, want %q", s.cmds[1], want)
+	}
+}
+
+func TestSend8BitMIME(t *testing.T) {
+	l := listenTest(t, "tcp", "127.0.0.1:0")
+	done := serveESMTP(l, []string{"8BITMIME"}, nil)
+
+	m := NewMessage()
+	m.SetHeader("From", testFrom)
+	m.SetHeader("To", testTo1)
+	m.SetBody("text/plain", "¡Hola, señor!\n")
+	m.AddAlternative("text/html", strings.Repeat("a", 999))
+
+	if err := testNetworkDialer(l).DialAndSend(m); err != nil {
+		t.Fatalf("DialAndSend(): %v", err)
+	}
+
+	s := <-done
+	if want := "MAIL FROM:<" + testFrom + "> BODY=8BITMIME"; s.cmds[1] != want {
+		t.Errorf("Invalid MAIL command, got %q, want %q", s.cmds[1], want)
+	}
+	if !strings.Contains(s.data, "Content-Transfer-Encoding: 8bit\r\n") ||
+		!strings.Contains(s.data, "\r\n\r\n¡Hola, señor!\r\n") {
+		t.Errorf("Text part should be sent in 8bit: %q", s.data)
+	}
+	// Lines over 998 octets cannot be sent in 8bit.
+	if !strings.Contains(s.data, "Content-Transfer-Encoding: quoted-printable") {
+		t.Errorf("Long part should be sent in quoted-printable: %q", s.data)
+	}
+}
+
+func TestSendBinaryMIME(t *testing.T) {
+	l := listenTest(t, "tcp", "127.0.0.1:0")
+	done := serveESMTP(l, []string{"CHUNKING", "8BITMIME", "BINARYMIME"}, nil)
+
+	m := getTestMessage()
+	m.Attach(mockCopyFile("test.pdf"))
+
+	if err := testNetworkDialer(l).DialAndSend(m); err != nil {
+		t.Fatalf("DialAndSend(): %v", err)
+	}
+
+	s := <-done
+	if want := "MAIL FROM:<" + testFrom + "> BODY=BINARYMIME"; s.cmds[1] != want {
+		t.Errorf("Invalid MAIL command, got %q, want %q", s.cmds[1], want)
+	}
+	if !strings.Contains(s.data, "Content-Transfer-Encoding: binary\r\n") ||
+		!strings.Contains(s.data, "\r\n\r\nContent of test.pdf\r\n") {
+		t.Errorf("Attachment should be sent in binary: %q", s.data)
+	}
+
+	// The message itself is not modified.
+	if m.attachments[0].Header["Content-Transfer-Encoding"][0] != "base64" {
+		t.Error("Attachment header should not be modified")
+	}
+}
+
+func TestSendBinaryMIMEUnchanged(t *testing.T) {
+	l := listenTest(t, "tcp", "127.0.0.1:0")
+	done := serveESMTP(l, []string{"CHUNKING", "BINARYMIME", "SIZE"}, nil)
+
+	content := "AA\nBB\x00\nCC\r"
+	m := getTestMessage()
+	m.Attach("test.bin", SetCopyFunc(func(w io.Writer) error {
+		_, err := io.WriteString(w, content)
+		return err
+	}))
+
+	if err := testNetworkDialer(l).DialAndSend(m); err != nil {
+		t.Fatalf("DialAndSend(): %v", err)
+	}
+
+	s := <-done
+	if !strings.Contains(s.data, "\r\n\r\n"+content+"\r\n--") {
+		t.Errorf("Binary attachment should be sent unchanged: %q", s.data)
+	}
+	if text := strings.Replace(s.data, content, "", 1); strings.Count(text, "\n") != strings.Count(text, "\r\n") {
+		t.Errorf("Text should have CRLF line endings: %q", s.data)
+	}
+	if want := "MAIL FROM:<" + testFrom + "> BODY=BINARYMIME SIZE=" + strconv.Itoa(len(s.data)); s.cmds[1] != want {
+		t.Errorf("Invalid MAIL command, got %q, want %q", s.cmds[1], want)
+	}
+}
+
+func TestSendRetryPrepared(t *testing.T) {
+	ext := []string{"CHUNKING", "BINARYMIME", "DSN"}
+
+	// The first connection is closed on MAIL, as after a timeout.
+	l := listenTest(t, "tcp", "127.0.0.1:0")
+	retry := make(chan (<-chan *esmtpSession), 1)
+	serveTest(l, func(c *textproto.Conn) {
+		for {
+			line, err := c.ReadLine()
+			if err != nil {
+				return
+			}
+			switch {
+			case strings.HasPrefix(line, "EHLO "):
+				c.PrintfLine("250-localhost")
+				for _, e := range ext {
+					c.PrintfLine("250-%s", e)
+				}
+				c.PrintfLine("250 HELP")
+			case strings.HasPrefix(line, "MAIL"):
+				retry <- serveESMTP(l, ext, nil)
+				return
+			default:
+				c.PrintfLine("250 2.0.0 OK")
+			}
+		}
+	})
+
+	m := getTestMessage()
+	m.applySettings([]MessageSetting{SetDSN(&DSN{Return: DSNReturnHeaders})})
+	m.Attach(mockCopyFile("test.pdf"))
+
+	d := testNetworkDialer(l)
+	d.RetryFailure = true
+	if err := d.DialAndSend(m); err != nil {
+		t.Fatalf("DialAndSend(): %v", err)
+	}
+
+	s := <-<-retry
+	if want := "MAIL FROM:<" + testFrom + "> BODY=BINARYMIME RET=HDRS"; s.cmds[1] != want {
+		t.Errorf("Invalid MAIL command after retry, got %q, want %q", s.cmds[1], want)
+	}
+	if !strings.Contains(s.data, "Content-Transfer-Encoding: binary\r\n") {
+		t.Errorf("Attachment should be sent in binary after retry: %q", s.data)
+	}
+}
+
+func TestFits8bit(t *testing.T) {
+	tests := []struct {
+		body string
+		want bool
+	}{
+		{"", true},
+		{"¡Hola!\r\nSeñor\r\n", true},
+		{strings.Repeat("a", 998) + "\r\n", true},
+		{strings.Repeat("a", 999), false},
+		{"a\x00b", false},
+		{"a\rb\r\n", false},
+	}
+
+	for _, test := range tests {
+		if got := fits8bit([]byte(test.body)); got != test.want {
+			t.Errorf("fits8bit(%q) = %v, want %v", test.body, got, test.want)
+		}
+	}
+}
@@ -3,6 +3,15 @@ module github.com/go-mail/mail
 go 1.24.3
 
 require (
+	github.com/ProtonMail/go-crypto v1.1.6
+	go.mozilla.org/pkcs7 v0.9.0
+	golang.org/x/text v0.14.0
 	gopkg.in/alexcesaro/quotedprintable.v3 v3.0.0-20150716171945-2caba252f4dc
 	gopkg.in/mail.v2 v2.3.1
 )
+
+require (
+	github.com/cloudflare/circl v1.3.7 // indirect
+	golang.org/x/crypto v0.17.0 // indirect
+	golang.org/x/sys v0.16.0 // indirect
+)
@@ -0,0 +1,125 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"text/template"
+)
+
+// DefaultHTTPTemplate is the request template used when HTTPSender.Template is
+// empty. The message is base64 encoded, since JSON strings cannot hold the
+// bytes of an 8bit message that are not valid UTF-8.
+const DefaultHTTPTemplate = `{"from":{{json .From}},"to":{{json .To}},"raw":"{{base64 .Raw}}"}`
+
+// An HTTPSender sends emails by posting them as JSON to an HTTP API, for
+// environments where outbound SMTP is blocked.
+//
+// The request body is rendered from Template, a text/template executed with
+// an HTTPMessage. The json function encodes a value as JSON and the base64
+// function encodes a string with standard base64, for example:
+//
+//	{"sender":{{json .From}},"recipients":{{json .To}},"mime":"{{base64 .Raw}}"}
+type HTTPSender struct {
+	// URL is the endpoint the messages are posted to.
+	URL string
+	// Header holds the fields added to each request, such as Authorization.
+	Header http.Header
+	// Template is the template of the request body, it defaults to
+	// DefaultHTTPTemplate.
+	Template string
+	// Client is the client used to send the requests, it defaults to
+	// http.DefaultClient.
+	Client *http.Client
+}
+
+// An HTTPMessage is the data given to the template of an HTTPSender.
+type HTTPMessage struct {
+	// From is the envelope sender.
+	From string
+	// To are the envelope recipients, including Bcc recipients.
+	To []string
+	// Raw is the MIME message, without its Bcc fields.
+	Raw string
+}
+
+var httpFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	"base64": func(s string) string {
+		return base64.StdEncoding.EncodeToString([]byte(s))
+	},
+}
+
+// Send implements Sender.
+func (s *HTTPSender) Send(from string, to []string, msg io.WriterTo) error {
+	return s.SendContext(context.Background(), from, to, msg)
+}
+
+// SendContext implements ContextSender.
+func (s *HTTPSender) SendContext(ctx context.Context, from string, to []string, msg io.WriterTo) error {
+	text := s.Template
+	if text == "" {
+		text = DefaultHTTPTemplate
+	}
+
+	tmpl, err := template.New("request").Funcs(httpFuncs).Parse(text)
+	if err != nil {
+		return fmt.Errorf("gomail: invalid HTTP template: %v", err)
+	}
+
+	var raw strings.Builder
+	if _, err := msg.WriteTo(&raw); err != nil {
+		return err
+	}
+
+	var body bytes.Buffer
+	if err := tmpl.Execute(&body, HTTPMessage{From: from, To: to, Raw: raw.String()}); err != nil {
+		return fmt.Errorf("gomail: invalid HTTP template: %v", err)
+	}
+	if !json.Valid(body.Bytes()) {
+		return fmt.Errorf("gomail: invalid HTTP template: request body is not JSON")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, &body)
+	if err != nil {
+		return err
+	}
+	for k, v := range s.Header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		if out := strings.TrimSpace(string(b)); out != "" {
+			return fmt.Errorf("gomail: %s returned %s: %s", s.URL, resp.Status, out)
+		}
+		return fmt.Errorf("gomail: %s returned %s", s.URL, resp.Status)
+	}
+
+	return nil
+}
+
+// Close implements SendCloser. It does nothing.
+func (s *HTTPSender) Close() error {
+	return nil
+}
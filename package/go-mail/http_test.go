@@ -0,0 +1,136 @@
+package mail
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestHTTPSender(t *testing.T) {
+	var got HTTPMessage
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			t.Errorf("Invalid method %q", r.Method)
+		}
+		if r.Header.Get("Authorization") != "Bearer token" {
+			t.Errorf("Invalid Authorization header %q", r.Header.Get("Authorization"))
+		}
+		if r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("Invalid Content-Type header %q", r.Header.Get("Content-Type"))
+		}
+
+		var body struct {
+			From string   `json:"from"`
+			To   []string `json:"to"`
+			Raw  string   `json:"raw"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Error(err)
+		}
+		raw, err := base64.StdEncoding.DecodeString(body.Raw)
+		if err != nil {
+			t.Error(err)
+		}
+		got = HTTPMessage{From: body.From, To: body.To, Raw: string(raw)}
+
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer ts.Close()
+
+	s := &HTTPSender{
+		URL:    ts.URL,
+		Header: http.Header{"Authorization": {"Bearer token"}},
+	}
+
+	m := getTestMessage()
+	m.SetHeader("Bcc", "bcc@example.com")
+
+	if err := Send(s, m); err != nil {
+		t.Fatalf("Send(): %v", err)
+	}
+
+	if got.From != testFrom || !reflect.DeepEqual(got.To, []string{testTo1, testTo2, "bcc@example.com"}) {
+		t.Errorf("Invalid envelope, got %q and %q", got.From, got.To)
+	}
+	compareBodies(t, got.Raw, testMsg)
+
+	// Latin-1 text is not valid UTF-8.
+	data := "From: " + testFrom + "\r\nTo: " + testTo1 + "\r\n" +
+		"Content-Type: text/plain; charset=ISO-8859-1\r\n" +
+		"Content-Transfer-Encoding: 8bit\r\n\r\nCaf\xe9\r\n"
+	r, err := NewRawMessage([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := SendRaw(s, r); err != nil {
+		t.Fatalf("SendRaw(): %v", err)
+	}
+	if got.Raw != data {
+		t.Errorf("Invalid raw message, got %q, want %q", got.Raw, data)
+	}
+}
+
+func TestHTTPSenderTemplate(t *testing.T) {
+	var got map[string]string
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer ts.Close()
+
+	s := &HTTPSender{
+		URL:      ts.URL,
+		Template: `{"sender":{{json .From}},"mime":"{{base64 .Raw}}"}`,
+	}
+
+	if err := Send(s, getTestMessage()); err != nil {
+		t.Fatalf("Send(): %v", err)
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(got["mime"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got["sender"] != testFrom {
+		t.Errorf("Invalid sender %q", got["sender"])
+	}
+	compareBodies(t, string(raw), testMsg)
+}
+
+func TestHTTPSenderError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		http.Error(w, "invalid API key", http.StatusUnauthorized)
+	}))
+	defer ts.Close()
+
+	s := &HTTPSender{URL: ts.URL}
+	if err := Send(s, getTestMessage()); err == nil || !strings.Contains(err.Error(), "401 Unauthorized: invalid API key") {
+		t.Errorf("Send() error, got %v", err)
+	}
+
+	for _, tmpl := range []string{`{{.Invalid`, `{{.Invalid}}`, `{"raw":{{.Raw}}}`} {
+		s.Template = tmpl
+		if err := Send(s, getTestMessage()); err == nil || !strings.Contains(err.Error(), "invalid HTTP template") {
+			t.Errorf("Send() with template %q error, got %v", tmpl, err)
+		}
+	}
+
+	s.Template = ""
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := SendContext(ctx, s, getTestMessage()); !errors.Is(err, context.Canceled) {
+		t.Errorf("SendContext() error, got %v, want %v", err, context.Canceled)
+	}
+}
@@ -0,0 +1,477 @@
+package mail
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"io"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// An LMTPDialer is a dialer to an LMTP server (RFC 2033), such as the local
+// delivery agent of Dovecot or Cyrus.
+//
+// Unlike SMTP, an LMTP server replies to the end of the data once per
+// recipient. A message is delivered to the accepted recipients even if others
+// are rejected, in which case sending returns a *RecipientError. The senders it
+// dials are StatusSenders reporting the reply to every recipient.
+type LMTPDialer struct {
+	// Network is "tcp" or "unix", it defaults to "tcp".
+	Network string
+	// Address is the host:port of the LMTP server, or the path of its socket.
+	Address string
+	// LocalName is the hostname sent to the LMTP server with the LHLO command.
+	// By default, "localhost" is sent.
+	LocalName string
+	// Auth represents the authentication mechanism used to authenticate to the
+	// LMTP server. Most LMTP servers only accept trusted local connections and
+	// do not need one.
+	Auth smtp.Auth
+	// TLSConfig, if set, is used to encrypt the connection with STARTTLS. The
+	// connection fails if the server does not support it.
+	TLSConfig *tls.Config
+	// Timeout to use for read/write operations. Defaults to 10 seconds, can
+	// be set to 0 to disable timeouts.
+	Timeout time.Duration
+}
+
+// NewLMTPDialer returns a new LMTP Dialer for the given network and address,
+// for example "tcp" and "localhost:24" or "unix" and
+// "/var/run/dovecot/lmtp".
+func NewLMTPDialer(network, address string) *LMTPDialer {
+	return &LMTPDialer{
+		Network: network,
+		Address: address,
+		Timeout: 10 * time.Second,
+	}
+}
+
+// Dial dials and greets an LMTP server. The returned SendCloser should be
+// closed when done using it.
+func (d *LMTPDialer) Dial() (SendCloser, error) {
+	return d.DialContext(context.Background())
+}
+
+// DialContext is like Dial but aborts when ctx is done.
+func (d *LMTPDialer) DialContext(ctx context.Context) (SendCloser, error) {
+	network := d.Network
+	if network == "" {
+		network = "tcp"
+	}
+
+	conn, err := (&net.Dialer{Timeout: d.Timeout}).DialContext(ctx, network, d.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	if deadline := timeoutDeadline(ctx, d.Timeout); !deadline.IsZero() {
+		conn.SetDeadline(deadline)
+	}
+
+	stop := watchContext(ctx, conn)
+	c, err := d.dial(conn)
+	if cerr := stop(); cerr != nil {
+		conn.Close()
+		return nil, cerr
+	}
+	if err != nil {
+		conn.Close()
+		if cerr := contextError(ctx); cerr != nil {
+			return nil, cerr
+		}
+		return nil, err
+	}
+
+	// The deadline of ctx only bounds dialing, as with Dial the connection
+	// keeps the Timeout.
+	conn.SetDeadline(timeoutDeadline(context.Background(), d.Timeout))
+
+	return &lmtpSender{c: c, d: d}, nil
+}
+
+func (d *LMTPDialer) dial(conn net.Conn) (*lmtpClient, error) {
+	c, err := newLMTPClient(conn, d.serverName())
+	if err != nil {
+		return nil, err
+	}
+
+	localName := d.LocalName
+	if localName == "" {
+		localName = "localhost"
+	}
+
+	if err := c.Hello(localName); err != nil {
+		return nil, err
+	}
+
+	if d.TLSConfig != nil {
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			return nil, StartTLSUnsupportedError{Policy: MandatoryStartTLS}
+		}
+		if err := c.StartTLS(d.TLSConfig); err != nil {
+			return nil, err
+		}
+	}
+
+	if d.Auth != nil {
+		if err := c.Auth(d.Auth); err != nil {
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+// serverName returns the name of the server used to authenticate, which is
+// localhost for a Unix socket.
+func (d *LMTPDialer) serverName() string {
+	if d.Network == "unix" {
+		return "localhost"
+	}
+
+	host, _, err := net.SplitHostPort(d.Address)
+	if err != nil {
+		return d.Address
+	}
+
+	return host
+}
+
+// DialAndSend opens a connection to the LMTP server, sends the given emails
+// and closes the connection.
+func (d *LMTPDialer) DialAndSend(m ...*Message) error {
+	return d.DialAndSendContext(context.Background(), m...)
+}
+
+// DialAndSendContext is like DialAndSend but aborts when ctx is done.
+func (d *LMTPDialer) DialAndSendContext(ctx context.Context, m ...*Message) error {
+	s, err := d.DialContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	return SendContext(ctx, s, m...)
+}
+
+// A StatusSender reports the reply of the server to each recipient of the last
+// email it sent.
+type StatusSender interface {
+	SendCloser
+	// Statuses returns the statuses in the order of the recipients, or nil if
+	// sending failed before the server replied to each of them.
+	Statuses() []RecipientStatus
+}
+
+type lmtpSender struct {
+	c        *lmtpClient
+	d        *LMTPDialer
+	statuses []RecipientStatus
+}
+
+var _ StatusSender = (*lmtpSender)(nil)
+
+func (s *lmtpSender) Statuses() []RecipientStatus {
+	return s.statuses
+}
+
+func (s *lmtpSender) Send(from string, to []string, msg io.WriterTo) error {
+	return s.SendContext(context.Background(), from, to, msg)
+}
+
+// SendContext is like Send but aborts the LMTP transaction when ctx is done.
+func (s *lmtpSender) SendContext(ctx context.Context, from string, to []string, msg io.WriterTo) error {
+	if deadline := timeoutDeadline(ctx, s.d.Timeout); !deadline.IsZero() {
+		s.c.conn.SetDeadline(deadline)
+	}
+
+	stop := watchContext(ctx, s.c.conn)
+	err := s.send(from, to, msg)
+	if cerr := stop(); cerr != nil {
+		return cerr
+	}
+	if err != nil {
+		if cerr := contextError(ctx); cerr != nil {
+			return cerr
+		}
+	}
+
+	return err
+}
+
+func (s *lmtpSender) send(from string, to []string, msg io.WriterTo) error {
+	s.statuses = nil
+
+	if err := s.c.Mail(from); err != nil {
+		return err
+	}
+
+	var rejected []RecipientStatus
+
+	statuses := make([]RecipientStatus, len(to))
+	// accepted are the indexes in to of the recipients accepted at RCPT.
+	var accepted []int
+
+	for i, addr := range to {
+		if err := s.c.Rcpt(addr); err != nil {
+			status, ok := recipientStatus(addr, err)
+			if !ok {
+				return err
+			}
+			rejected = append(rejected, status)
+			statuses[i] = status
+		} else {
+			accepted = append(accepted, i)
+		}
+	}
+
+	if len(s.c.rcpts) == 0 {
+		if err := s.c.Reset(); err != nil {
+			return err
+		}
+		s.statuses = statuses
+		return &RecipientError{Rejected: rejected}
+	}
+
+	w, err := s.c.Data()
+	if err != nil {
+		return err
+	}
+
+	if _, err = msg.WriteTo(w); err != nil {
+		w.Close()
+		return err
+	}
+
+	if err := w.Close(); err != nil {
+		rerr, ok := err.(*RecipientError)
+		if !ok {
+			return err
+		}
+		rejected = append(rejected, rerr.Rejected...)
+	}
+
+	for i, status := range s.c.replies {
+		statuses[accepted[i]] = status
+	}
+	s.statuses = statuses
+
+	if len(rejected) != 0 {
+		return &RecipientError{Rejected: rejected}
+	}
+
+	return nil
+}
+
+func (s *lmtpSender) Close() error {
+	return s.c.Quit()
+}
+
+// recipientStatus converts the rejection of a recipient by the server. Other
+// errors, such as network errors, are not converted.
+func recipientStatus(addr string, err error) (RecipientStatus, bool) {
+	terr, ok := err.(*textproto.Error)
+	if !ok {
+		return RecipientStatus{}, false
+	}
+
+	return RecipientStatus{Address: addr, Code: terr.Code, Message: terr.Msg}, true
+}
+
+// lmtpClient implements smtpClient for LMTP. The net/smtp client cannot be
+// used since it greets the server with EHLO and reads a single reply to DATA.
+type lmtpClient struct {
+	text       *textproto.Conn
+	conn       net.Conn
+	serverName string
+	localName  string
+	ext        map[string]string
+	// rcpts are the recipients accepted in the current transaction.
+	rcpts []string
+	// replies are the replies to the data of the last transaction, one per
+	// accepted recipient.
+	replies []RecipientStatus
+}
+
+var _ smtpClient = (*lmtpClient)(nil)
+
+func newLMTPClient(conn net.Conn, serverName string) (*lmtpClient, error) {
+	text := textproto.NewConn(conn)
+	if _, _, err := text.ReadResponse(220); err != nil {
+		text.Close()
+		return nil, err
+	}
+
+	return &lmtpClient{text: text, conn: conn, serverName: serverName}, nil
+}
+
+func (c *lmtpClient) cmd(expectCode int, format string, args ...interface{}) (int, string, error) {
+	return textCmd(c.text, expectCode, format, args...)
+}
+
+func (c *lmtpClient) Hello(localName string) error {
+	_, msg, err := c.cmd(250, "LHLO %s", localName)
+	if err != nil {
+		return err
+	}
+
+	c.localName = localName
+	c.ext = make(map[string]string)
+	lines := strings.Split(msg, "\n")
+	for _, line := range lines[1:] {
+		args := strings.SplitN(line, " ", 2)
+		if len(args) > 1 {
+			c.ext[strings.ToUpper(args[0])] = args[1]
+		} else {
+			c.ext[strings.ToUpper(args[0])] = ""
+		}
+	}
+
+	return nil
+}
+
+func (c *lmtpClient) Extension(ext string) (bool, string) {
+	param, ok := c.ext[strings.ToUpper(ext)]
+	return ok, param
+}
+
+func (c *lmtpClient) StartTLS(config *tls.Config) error {
+	if _, _, err := c.cmd(220, "STARTTLS"); err != nil {
+		return err
+	}
+
+	c.conn = tls.Client(c.conn, config)
+	c.text = textproto.NewConn(c.conn)
+
+	return c.Hello(c.localName)
+}
+
+// Auth follows the exchange of net/smtp.Client.Auth.
+func (c *lmtpClient) Auth(a smtp.Auth) error {
+	encoding := base64.StdEncoding
+	_, isTLS := c.conn.(*tls.Conn)
+	_, mechs := c.Extension("AUTH")
+
+	mech, resp, err := a.Start(&smtp.ServerInfo{Name: c.serverName, TLS: isTLS, Auth: strings.Fields(mechs)})
+	if err != nil {
+		c.Quit()
+		return err
+	}
+
+	code, msg64, err := c.cmd(0, "%s", strings.TrimSpace("AUTH "+mech+" "+encoding.EncodeToString(resp)))
+	for err == nil {
+		var msg []byte
+		switch code {
+		case 334:
+			msg, err = encoding.DecodeString(msg64)
+		case 235:
+			msg = []byte(msg64)
+		default:
+			err = &textproto.Error{Code: code, Msg: msg64}
+		}
+		if err == nil {
+			resp, err = a.Next(msg, code == 334)
+		}
+		if err != nil {
+			// Abort the exchange.
+			c.cmd(501, "*")
+			c.Quit()
+			break
+		}
+		if resp == nil {
+			break
+		}
+		code, msg64, err = c.cmd(0, "%s", encoding.EncodeToString(resp))
+	}
+
+	return err
+}
+
+func (c *lmtpClient) Mail(from string) error {
+	c.rcpts = nil
+	c.replies = nil
+
+	cmd := "MAIL FROM:<%s>"
+	if _, ok := c.ext["8BITMIME"]; ok {
+		cmd += " BODY=8BITMIME"
+	}
+	_, _, err := c.cmd(250, cmd, from)
+
+	return err
+}
+
+func (c *lmtpClient) Rcpt(to string) error {
+	if _, _, err := c.cmd(25, "RCPT TO:<%s>", to); err != nil {
+		return err
+	}
+	c.rcpts = append(c.rcpts, to)
+
+	return nil
+}
+
+func (c *lmtpClient) Data() (io.WriteCloser, error) {
+	if _, _, err := c.cmd(354, "DATA"); err != nil {
+		return nil, err
+	}
+
+	return &lmtpDataWriter{c.text.DotWriter(), c}, nil
+}
+
+func (c *lmtpClient) Reset() error {
+	c.rcpts = nil
+	_, _, err := c.cmd(250, "RSET")
+
+	return err
+}
+
+func (c *lmtpClient) Quit() error {
+	if _, _, err := c.cmd(221, "QUIT"); err != nil {
+		return err
+	}
+
+	return c.text.Close()
+}
+
+func (c *lmtpClient) Close() error {
+	return c.text.Close()
+}
+
+// lmtpDataWriter reads one reply per accepted recipient once the data is
+// written.
+type lmtpDataWriter struct {
+	io.WriteCloser
+	c *lmtpClient
+}
+
+func (w *lmtpDataWriter) Close() error {
+	if err := w.WriteCloser.Close(); err != nil {
+		return err
+	}
+
+	var rejected []RecipientStatus
+
+	for _, addr := range w.c.rcpts {
+		code, msg, err := w.c.text.ReadResponse(250)
+		if err != nil {
+			status, ok := recipientStatus(addr, err)
+			if !ok {
+				return err
+			}
+			rejected = append(rejected, status)
+			w.c.replies = append(w.c.replies, status)
+			continue
+		}
+		w.c.replies = append(w.c.replies, RecipientStatus{Address: addr, Code: code, Message: msg})
+	}
+	w.c.rcpts = nil
+
+	if len(rejected) != 0 {
+		return &RecipientError{Rejected: rejected}
+	}
+
+	return nil
+}
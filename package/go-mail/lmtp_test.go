@@ -0,0 +1,223 @@
+package mail
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/textproto"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLMTPDialer(t *testing.T) {
+	l := listenTest(t, "unix", filepath.Join(t.TempDir(), "lmtp"))
+	done := serveLMTP(l, nil, nil)
+
+	d := NewLMTPDialer("unix", l.Addr().String())
+	if err := d.DialAndSend(getTestMessage()); err != nil {
+		t.Fatalf("DialAndSend(): %v", err)
+	}
+
+	want := []string{
+		"LHLO localhost",
+		"MAIL FROM:<" + testFrom + "> BODY=8BITMIME",
+		"RCPT TO:<" + testTo1 + ">",
+		"RCPT TO:<" + testTo2 + ">",
+		"DATA",
+		"QUIT",
+	}
+	if got := <-done; !reflect.DeepEqual(got, want) {
+		t.Errorf("Invalid commands, got %q, want %q", got, want)
+	}
+}
+
+func TestLMTPDialerRejected(t *testing.T) {
+	l := listenTest(t, "tcp", "127.0.0.1:0")
+	done := serveLMTP(l, map[string]string{
+		testTo1: "550 5.1.1 User unknown",
+	}, map[string]string{
+		testTo2: "452 4.2.2 Mailbox full",
+	})
+
+	m := getTestMessage()
+	m.SetHeader("Cc", "cc@example.com")
+
+	err := NewLMTPDialer("tcp", l.Addr().String()).DialAndSend(m)
+
+	var rerr *RecipientError
+	if !errors.As(err, &rerr) {
+		t.Fatalf("DialAndSend() error, got %v, want a RecipientError", err)
+	}
+
+	want := []RecipientStatus{
+		{Address: testTo1, Code: 550, Message: "5.1.1 User unknown"},
+		{Address: testTo2, Code: 452, Message: "4.2.2 Mailbox full"},
+	}
+	if !reflect.DeepEqual(rerr.Rejected, want) {
+		t.Errorf("Invalid statuses, got %+v, want %+v", rerr.Rejected, want)
+	}
+	if !strings.Contains(err.Error(), testTo1+": 550 5.1.1 User unknown") {
+		t.Errorf("Invalid error %q", err)
+	}
+
+	<-done
+}
+
+func TestLMTPSenderStatuses(t *testing.T) {
+	l := listenTest(t, "tcp", "127.0.0.1:0")
+	serveLMTP(l, map[string]string{
+		testTo1: "550 5.1.1 User unknown",
+	}, map[string]string{
+		testTo2: "452 4.2.2 Mailbox full",
+	})
+
+	s, err := NewLMTPDialer("tcp", l.Addr().String()).Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	ss, ok := s.(StatusSender)
+	if !ok {
+		t.Fatal("The LMTP sender should be a StatusSender")
+	}
+
+	m := getTestMessage()
+	m.SetHeader("Cc", "cc@example.com")
+
+	var rerr *RecipientError
+	if err := Send(s, m); !errors.As(err, &rerr) {
+		t.Fatalf("Send() error, got %v, want a RecipientError", err)
+	}
+
+	want := []RecipientStatus{
+		{Address: testTo1, Code: 550, Message: "5.1.1 User unknown"},
+		{Address: testTo2, Code: 452, Message: "4.2.2 Mailbox full"},
+		{Address: "cc@example.com", Code: 250, Message: "2.0.0 <cc@example.com> Saved"},
+	}
+	if got := ss.Statuses(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Invalid statuses, got %+v, want %+v", got, want)
+	}
+}
+
+func TestLMTPDialerAllRejected(t *testing.T) {
+	l := listenTest(t, "tcp", "127.0.0.1:0")
+	done := serveLMTP(l, map[string]string{
+		testTo1: "550 5.1.1 User unknown",
+		testTo2: "550 5.1.1 User unknown",
+	}, nil)
+
+	s, err := NewLMTPDialer("tcp", l.Addr().String()).Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var rerr *RecipientError
+	if err := Send(s, getTestMessage()); !errors.As(err, &rerr) || len(rerr.Rejected) != 2 {
+		t.Errorf("Send() error, got %v, want a RecipientError", err)
+	}
+
+	if got := s.(StatusSender).Statuses(); !reflect.DeepEqual(got, rerr.Rejected) {
+		t.Errorf("Invalid statuses, got %+v, want %+v", got, rerr.Rejected)
+	}
+
+	// The session is still usable.
+	if err := s.Close(); err != nil {
+		t.Errorf("Close(): %v", err)
+	}
+
+	want := []string{
+		"LHLO localhost",
+		"MAIL FROM:<" + testFrom + "> BODY=8BITMIME",
+		"RCPT TO:<" + testTo1 + ">",
+		"RCPT TO:<" + testTo2 + ">",
+		"RSET",
+		"QUIT",
+	}
+	if got := <-done; !reflect.DeepEqual(got, want) {
+		t.Errorf("Invalid commands, got %q, want %q", got, want)
+	}
+}
+
+func TestLMTPDialerContextDeadlineReset(t *testing.T) {
+	l := listenTest(t, "tcp", "127.0.0.1:0")
+	serveLMTP(l, nil, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	d := NewLMTPDialer("tcp", l.Addr().String())
+	d.Timeout = 0
+
+	s, err := d.DialContext(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	<-ctx.Done()
+
+	if err := Send(s, getTestMessage()); err != nil {
+		t.Errorf("Send() after the dial context expired: %v", err)
+	}
+}
+
+// serveLMTP runs a fake LMTP server on l and returns the commands it received
+// once the client disconnects. Recipients in rcpt are rejected at RCPT and
+// those in data after the data, with the given replies.
+func serveLMTP(l net.Listener, rcpt, data map[string]string) <-chan []string {
+	done := make(chan []string, 1)
+
+	serveTest(l, func(c *textproto.Conn) {
+		var cmds, rcpts []string
+		defer func() { done <- cmds }()
+
+		for {
+			line, err := c.ReadLine()
+			if err != nil {
+				return
+			}
+			cmds = append(cmds, line)
+
+			switch {
+			case strings.HasPrefix(line, "LHLO "):
+				c.PrintfLine("250-localhost")
+				c.PrintfLine("250-PIPELINING")
+				c.PrintfLine("250 8BITMIME")
+			case strings.HasPrefix(line, "MAIL FROM:"):
+				rcpts = nil
+				c.PrintfLine("250 2.1.0 OK")
+			case strings.HasPrefix(line, "RCPT TO:"):
+				addr := strings.Trim(strings.TrimPrefix(line, "RCPT TO:"), "<>")
+				if reply, ok := rcpt[addr]; ok {
+					c.PrintfLine("%s", reply)
+					continue
+				}
+				rcpts = append(rcpts, addr)
+				c.PrintfLine("250 2.1.5 OK")
+			case line == "DATA":
+				c.PrintfLine("354 OK")
+				if _, err := c.ReadDotBytes(); err != nil {
+					return
+				}
+				for _, addr := range rcpts {
+					if reply, ok := data[addr]; ok {
+						c.PrintfLine("%s", reply)
+					} else {
+						c.PrintfLine("250 2.0.0 <%s> Saved", addr)
+					}
+				}
+			case line == "QUIT":
+				c.PrintfLine("221 2.0.0 Bye")
+				return
+			default:
+				c.PrintfLine("250 2.0.0 OK")
+			}
+		}
+	})
+
+	return done
+}
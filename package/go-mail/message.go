@@ -19,6 +19,10 @@ type Message struct {
 	hEncoder    mimeEncoder
 	buf         bytes.Buffer
 	boundary    string
+	dkim        *DKIMSigner
+	smime       *SMIME
+	pgp         *PGP
+	dsn         *DSN
 }
 
 type header map[string][]string
@@ -96,6 +100,10 @@ const (
 	// Unencoded can be used to avoid encoding the body of an email. The headers
 	// will still be encoded using quoted-printable encoding.
 	Unencoded Encoding = "8bit"
+
+	// binaryEncoding is used for the files sent to servers supporting
+	// BINARYMIME.
+	binaryEncoding Encoding = "binary"
 )
 
 // SetBoundary sets a custom multipart boundary.
@@ -0,0 +1,128 @@
+package mail
+
+import (
+	"bytes"
+	"crypto"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+)
+
+// PGP configures OpenPGP/MIME (RFC 3156) protection of a message. The message
+// is signed if Signer is set and encrypted if Recipients is not empty. When
+// both are set, the signature is embedded in the encrypted data as described
+// in RFC 3156, section 6.2.
+type PGP struct {
+	// Signer is the signing entity. Its private key must be decrypted.
+	Signer *openpgp.Entity
+	// Recipients holds the entities the message is encrypted to.
+	Recipients []*openpgp.Entity
+	// Config is the OpenPGP configuration, nil uses the defaults.
+	Config *packet.Config
+}
+
+// SetPGP is a message setting to sign and/or encrypt the email with
+// OpenPGP/MIME when it is written.
+func SetPGP(p *PGP) MessageSetting {
+	return func(m *Message) {
+		m.pgp = p
+	}
+}
+
+var micalgNames = map[crypto.Hash]string{
+	crypto.SHA224: "pgp-sha224",
+	crypto.SHA256: "pgp-sha256",
+	crypto.SHA384: "pgp-sha384",
+	crypto.SHA512: "pgp-sha512",
+}
+
+func (p *PGP) wrap(entity []byte) ([]byte, error) {
+	if len(p.Recipients) > 0 {
+		return p.encrypt(entity)
+	}
+
+	if p.Signer != nil {
+		return p.sign(entity)
+	}
+
+	return nil, errors.New("gomail: PGP signer or recipients are required")
+}
+
+func (p *PGP) sign(entity []byte) ([]byte, error) {
+	micalg, ok := micalgNames[p.Config.Hash()]
+	if !ok {
+		return nil, fmt.Errorf("gomail: unsupported PGP hash %v", p.Config.Hash())
+	}
+
+	var sig bytes.Buffer
+	if err := openpgp.ArmoredDetachSign(&sig, p.Signer, bytes.NewReader(entity), p.Config); err != nil {
+		return nil, fmt.Errorf("gomail: PGP signing failed: %v", err)
+	}
+
+	boundary := multipart.NewWriter(io.Discard).Boundary()
+
+	var buf bytes.Buffer
+	buf.WriteString("Content-Type: multipart/signed; micalg=" + micalg + ";\r\n" +
+		" protocol=\"application/pgp-signature\"; boundary=\"" + boundary + "\"\r\n\r\n")
+	buf.WriteString("This is an OpenPGP/MIME signed message (RFC 3156)\r\n\r\n")
+	buf.WriteString("--" + boundary + "\r\n")
+	buf.Write(entity)
+	buf.WriteString("\r\n--" + boundary + "\r\n")
+	buf.WriteString("Content-Type: application/pgp-signature; name=\"signature.asc\"\r\n")
+	buf.WriteString("Content-Description: OpenPGP digital signature\r\n")
+	buf.WriteString("Content-Disposition: attachment; filename=\"signature.asc\"\r\n\r\n")
+	buf.Write(toCRLF(sig.Bytes()))
+	buf.WriteString("\r\n--" + boundary + "--\r\n")
+
+	return buf.Bytes(), nil
+}
+
+func (p *PGP) encrypt(entity []byte) ([]byte, error) {
+	var enc bytes.Buffer
+
+	aw, err := armor.Encode(&enc, "PGP MESSAGE", nil)
+	if err != nil {
+		return nil, fmt.Errorf("gomail: PGP encryption failed: %v", err)
+	}
+
+	pw, err := openpgp.Encrypt(aw, p.Recipients, p.Signer, nil, p.Config)
+	if err != nil {
+		return nil, fmt.Errorf("gomail: PGP encryption failed: %v", err)
+	}
+
+	if _, err := pw.Write(entity); err != nil {
+		return nil, fmt.Errorf("gomail: PGP encryption failed: %v", err)
+	}
+
+	if err := pw.Close(); err != nil {
+		return nil, fmt.Errorf("gomail: PGP encryption failed: %v", err)
+	}
+
+	if err := aw.Close(); err != nil {
+		return nil, fmt.Errorf("gomail: PGP encryption failed: %v", err)
+	}
+
+	boundary := multipart.NewWriter(io.Discard).Boundary()
+
+	var buf bytes.Buffer
+	buf.WriteString("Content-Type: multipart/encrypted; protocol=\"application/pgp-encrypted\";\r\n" +
+		" boundary=\"" + boundary + "\"\r\n\r\n")
+	buf.WriteString("This is an OpenPGP/MIME encrypted message (RFC 3156)\r\n\r\n")
+	buf.WriteString("--" + boundary + "\r\n")
+	buf.WriteString("Content-Type: application/pgp-encrypted\r\n")
+	buf.WriteString("Content-Description: PGP/MIME version identification\r\n\r\n")
+	buf.WriteString("Version: 1\r\n")
+	buf.WriteString("\r\n--" + boundary + "\r\n")
+	buf.WriteString("Content-Type: application/octet-stream; name=\"encrypted.asc\"\r\n")
+	buf.WriteString("Content-Description: OpenPGP encrypted message\r\n")
+	buf.WriteString("Content-Disposition: inline; filename=\"encrypted.asc\"\r\n\r\n")
+	buf.Write(toCRLF(enc.Bytes()))
+	buf.WriteString("\r\n--" + boundary + "--\r\n")
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,217 @@
+package mail
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"mime/multipart"
+	stdmail "net/mail"
+	"strings"
+	"testing"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+)
+
+func TestPGPSign(t *testing.T) {
+	signer := newPGPEntity(t, "from@example.com")
+
+	m := NewMessage(SetPGP(&PGP{Signer: signer}))
+	m.SetHeader("From", "from@example.com")
+	m.SetHeader("To", "to@example.com")
+	m.SetHeader("Bcc", "bcc@example.com")
+	m.SetBody("text/plain", "¡Hola, señor!\n")
+	m.AddAlternative("text/html", "<p>¡Hola, señor!</p>")
+
+	msg := writeSMIMEMessage(t, m)
+
+	if msg.Header.Get("Bcc") != "" {
+		t.Error("Bcc header should not be written")
+	}
+
+	entity, sig := splitPGPSigned(t, msg)
+
+	if !strings.HasPrefix(string(entity), "Content-Type: multipart/alternative;") {
+		t.Errorf("Invalid signed entity: %q", entity)
+	}
+
+	verifyPGPSignature(t, openpgp.EntityList{signer}, entity, sig)
+}
+
+func TestPGPEncrypt(t *testing.T) {
+	recipient := newPGPEntity(t, "to@example.com")
+
+	m := NewMessage(SetPGP(&PGP{Recipients: []*openpgp.Entity{recipient}}))
+	m.SetHeader("From", "from@example.com")
+	m.SetHeader("To", "to@example.com")
+	m.SetBody("text/plain", "Secret")
+
+	md := decryptPGP(t, writeSMIMEMessage(t, m), openpgp.EntityList{recipient})
+
+	if md.IsSigned {
+		t.Error("Message should not be signed")
+	}
+
+	entity, err := io.ReadAll(md.UnverifiedBody)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "Content-Type: text/plain; charset=UTF-8\r\n" +
+		"Content-Transfer-Encoding: quoted-printable\r\n" +
+		"\r\n" +
+		"Secret"
+	compareBodies(t, string(entity), want)
+}
+
+func TestPGPSignAndEncrypt(t *testing.T) {
+	signer := newPGPEntity(t, "from@example.com")
+	recipient := newPGPEntity(t, "to@example.com")
+
+	m := NewMessage(SetPGP(&PGP{
+		Signer:     signer,
+		Recipients: []*openpgp.Entity{recipient},
+	}))
+	m.SetHeader("From", "from@example.com")
+	m.SetHeader("To", "to@example.com")
+	m.SetBody("text/plain", "Secret")
+	m.Attach(mockCopyFile("/tmp/test.pdf"))
+
+	md := decryptPGP(t, writeSMIMEMessage(t, m), openpgp.EntityList{recipient, signer})
+
+	if !md.IsSigned || md.SignedBy == nil || md.SignedBy.Entity != signer {
+		t.Fatal("Message should be signed by the signer")
+	}
+
+	entity, err := io.ReadAll(md.UnverifiedBody)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if md.SignatureError != nil {
+		t.Errorf("Invalid signature: %v", md.SignatureError)
+	}
+
+	if !strings.HasPrefix(string(entity), "Content-Type: multipart/mixed;") {
+		t.Errorf("Invalid decrypted entity: %q", entity)
+	}
+}
+
+func TestPGPError(t *testing.T) {
+	m := NewMessage(SetPGP(&PGP{}))
+	m.SetHeader("From", "from@example.com")
+	m.SetBody("text/plain", "Test")
+
+	if _, err := m.WriteTo(io.Discard); err == nil {
+		t.Error("WriteTo() should fail without a signer or recipients")
+	}
+}
+
+func newPGPEntity(t *testing.T, address string) *openpgp.Entity {
+	e, err := openpgp.NewEntity("", "", address, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return e
+}
+
+// splitPGPSigned returns the raw signed entity and the armored signature of a
+// multipart/signed message.
+func splitPGPSigned(t *testing.T, msg *stdmail.Message) ([]byte, []byte) {
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mediaType != "multipart/signed" || params["protocol"] != "application/pgp-signature" || params["micalg"] != "pgp-sha256" {
+		t.Fatalf("Invalid Content-Type: %q", msg.Header.Get("Content-Type"))
+	}
+
+	body, err := io.ReadAll(msg.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	delimiter := "--" + params["boundary"]
+	start := strings.Index(string(body), delimiter+"\r\n")
+	if start == -1 {
+		t.Fatalf("Invalid multipart/signed body: %q", body)
+	}
+	start += len(delimiter) + 2
+	end := strings.Index(string(body[start:]), "\r\n"+delimiter+"\r\n")
+	if end == -1 {
+		t.Fatalf("Invalid multipart/signed body: %q", body)
+	}
+	entity := body[start : start+end]
+
+	r := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+	if _, err := r.NextPart(); err != nil {
+		t.Fatal(err)
+	}
+	p, err := r.NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Header.Get("Content-Type") != `application/pgp-signature; name="signature.asc"` {
+		t.Errorf("Invalid signature Content-Type: %q", p.Header.Get("Content-Type"))
+	}
+	sig, err := io.ReadAll(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return entity, sig
+}
+
+func verifyPGPSignature(t *testing.T, keyring openpgp.EntityList, entity, sig []byte) {
+	if _, err := openpgp.CheckArmoredDetachedSignature(keyring, bytes.NewReader(entity), bytes.NewReader(sig), nil); err != nil {
+		t.Errorf("CheckArmoredDetachedSignature(): %v", err)
+	}
+
+	tampered := append(append([]byte{}, entity...), '!')
+	if _, err := openpgp.CheckArmoredDetachedSignature(keyring, bytes.NewReader(tampered), bytes.NewReader(sig), nil); err == nil {
+		t.Error("CheckArmoredDetachedSignature() should fail on a tampered entity")
+	}
+}
+
+func decryptPGP(t *testing.T, msg *stdmail.Message, keyring openpgp.EntityList) *openpgp.MessageDetails {
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mediaType != "multipart/encrypted" || params["protocol"] != "application/pgp-encrypted" {
+		t.Fatalf("Invalid Content-Type: %q", msg.Header.Get("Content-Type"))
+	}
+
+	r := multipart.NewReader(msg.Body, params["boundary"])
+	p, err := r.NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	version, err := io.ReadAll(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Header.Get("Content-Type") != "application/pgp-encrypted" || !strings.HasPrefix(string(version), "Version: 1") {
+		t.Errorf("Invalid version part: %q", version)
+	}
+
+	p, err = r.NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Header.Get("Content-Type") != `application/octet-stream; name="encrypted.asc"` {
+		t.Errorf("Invalid encrypted Content-Type: %q", p.Header.Get("Content-Type"))
+	}
+
+	block, err := armor.Decode(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	md, err := openpgp.ReadMessage(block.Body, keyring, nil, nil)
+	if err != nil {
+		t.Fatalf("ReadMessage(): %v", err)
+	}
+
+	return md
+}
@@ -0,0 +1,200 @@
+package mail
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+)
+
+// chunkSize is the size of the BDAT chunks sent with CHUNKING.
+const chunkSize = 1 << 20
+
+// A textClient is an SMTP client giving access to its connection, so that
+// commands can be pipelined (RFC 2920) and messages sent in chunks (RFC 3030).
+type textClient interface {
+	textConn() *textproto.Conn
+}
+
+// netClient wraps the net/smtp client, which cannot pipeline commands nor send
+// BDAT chunks itself.
+type netClient struct {
+	*smtp.Client
+}
+
+func (c netClient) textConn() *textproto.Conn {
+	return c.Text
+}
+
+func newSMTPClient(conn net.Conn, host string) (smtpClient, error) {
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		return nil, err
+	}
+
+	return netClient{c}, nil
+}
+
+// envelope sends MAIL and RCPT for each recipient with the given parameters.
+// With pipelining, the commands are sent at once and their replies read
+// afterwards, instead of waiting for each reply in turn. The transaction is
+// reset if the sender or a recipient is rejected.
+func (c *smtpSender) envelope(text *textproto.Conn, from string, to []string, params envelopeParams, pipelining bool) error {
+	for _, addr := range append([]string{from}, to...) {
+		if strings.ContainsAny(addr, "\r\n") {
+			return errors.New("gomail: a line must not contain CR or LF")
+		}
+	}
+
+	cmds := []string{strings.Join(append([]string{"MAIL FROM:<" + from + ">"}, params.mail...), " ")}
+	for _, addr := range to {
+		cmds = append(cmds, strings.Join(append([]string{"RCPT TO:<" + addr + ">"}, params.rcpt[addr]...), " "))
+	}
+
+	var err error
+	if pipelining {
+		err = pipelineCommands(text, cmds)
+	} else {
+		for _, cmd := range cmds {
+			if _, _, err = textCmd(text, 25, "%s", cmd); err != nil {
+				break
+			}
+		}
+	}
+
+	if _, ok := err.(*textproto.Error); ok {
+		if _, _, err := textCmd(text, 250, "RSET"); err != nil {
+			return err
+		}
+	}
+
+	return err
+}
+
+// pipelineCommands writes cmds at once and then reads their replies. It
+// returns the first rejection, or the first other error.
+func pipelineCommands(text *textproto.Conn, cmds []string) error {
+	id := text.Next()
+	text.StartRequest(id)
+	for _, cmd := range cmds {
+		text.W.WriteString(cmd + "\r\n")
+	}
+	err := text.W.Flush()
+	text.EndRequest(id)
+	if err != nil {
+		return err
+	}
+
+	text.StartResponse(id)
+	defer text.EndResponse(id)
+
+	var firstErr error
+	for range cmds {
+		if _, _, err := text.ReadResponse(25); err != nil {
+			if _, ok := err.(*textproto.Error); !ok {
+				return err
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+
+	return firstErr
+}
+
+// sendChunks sends the message with BDAT instead of DATA, which spares the
+// dot-stuffing of the message and its scanning by the server.
+func (c *smtpSender) sendChunks(text *textproto.Conn, msg io.WriterTo, binary bool) error {
+	w := &bdatWriter{text: text, buf: make([]byte, 0, chunkSize), binary: binary}
+
+	if _, err := msg.WriteTo(w); err != nil {
+		if w.err == nil {
+			// Abort the transaction, the server waits for more chunks.
+			textCmd(text, 250, "RSET")
+		}
+		return err
+	}
+
+	return w.Close()
+}
+
+// bdatWriter buffers a message and sends it in BDAT chunks. Like the writer
+// returned by DATA, it converts bare LF line endings to CRLF, unless the message
+// is binary.
+type bdatWriter struct {
+	text   *textproto.Conn
+	buf    []byte
+	cr     bool
+	binary bool
+	err    error
+}
+
+func (w *bdatWriter) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+
+	for _, b := range p {
+		if b == '\n' && !w.cr && !w.binary {
+			w.buf = append(w.buf, '\r')
+		}
+		w.buf = append(w.buf, b)
+		w.cr = b == '\r'
+
+		if len(w.buf) >= chunkSize {
+			if err := w.chunk(false); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	return len(p), nil
+}
+
+func (w *bdatWriter) Close() error {
+	if w.err != nil {
+		return w.err
+	}
+
+	return w.chunk(true)
+}
+
+func (w *bdatWriter) chunk(last bool) error {
+	id := w.text.Next()
+	w.text.StartRequest(id)
+	if last {
+		fmt.Fprintf(w.text.W, "BDAT %d LAST\r\n", len(w.buf))
+	} else {
+		fmt.Fprintf(w.text.W, "BDAT %d\r\n", len(w.buf))
+	}
+	w.text.W.Write(w.buf)
+	err := w.text.W.Flush()
+	w.text.EndRequest(id)
+
+	if err == nil {
+		w.text.StartResponse(id)
+		_, _, err = w.text.ReadResponse(250)
+		w.text.EndResponse(id)
+	}
+
+	w.buf = w.buf[:0]
+	w.err = err
+
+	return err
+}
+
+// textCmd sends a command and reads its reply.
+func textCmd(text *textproto.Conn, expectCode int, format string, args ...interface{}) (int, string, error) {
+	id, err := text.Cmd(format, args...)
+	if err != nil {
+		return 0, "", err
+	}
+	text.StartResponse(id)
+	defer text.EndResponse(id)
+
+	return text.ReadResponse(expectCode)
+}
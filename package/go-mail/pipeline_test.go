@@ -0,0 +1,198 @@
+package mail
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"net/textproto"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestSendPipelining(t *testing.T) {
+	l := listenTest(t, "tcp", "127.0.0.1:0")
+	done := serveESMTP(l, []string{"PIPELINING", "8BITMIME"}, nil)
+
+	if err := testNetworkDialer(l).DialAndSend(getTestMessage()); err != nil {
+		t.Fatalf("DialAndSend(): %v", err)
+	}
+
+	s := <-done
+	want := []string{
+		"EHLO localhost",
+		"MAIL FROM:<" + testFrom + "> BODY=8BITMIME",
+		"RCPT TO:<" + testTo1 + ">",
+		"RCPT TO:<" + testTo2 + ">",
+		"DATA",
+		"QUIT",
+	}
+	if !reflect.DeepEqual(s.cmds, want) {
+		t.Errorf("Invalid commands, got %q, want %q", s.cmds, want)
+	}
+	// The envelope is written at once.
+	if s.pipelined != 3 {
+		t.Errorf("Invalid pipelined commands, got %d, want 3", s.pipelined)
+	}
+	// The server reads the line ending added before the final dot.
+	compareBodies(t, s.data, strings.Replace(testMsg, "quoted-printable", "8bit", 1)+"\r\n")
+}
+
+func TestSendPipeliningRejected(t *testing.T) {
+	l := listenTest(t, "tcp", "127.0.0.1:0")
+	done := serveESMTP(l, []string{"PIPELINING"}, map[string]string{
+		testTo1: "550 5.1.1 User unknown",
+	})
+
+	err := testNetworkDialer(l).DialAndSend(getTestMessage())
+	if err == nil || !strings.Contains(err.Error(), "5.1.1 User unknown") {
+		t.Errorf("DialAndSend() error, got %v", err)
+	}
+
+	s := <-done
+	want := []string{
+		"EHLO localhost",
+		"MAIL FROM:<" + testFrom + ">",
+		"RCPT TO:<" + testTo1 + ">",
+		"RCPT TO:<" + testTo2 + ">",
+		"RSET",
+		"QUIT",
+	}
+	if !reflect.DeepEqual(s.cmds, want) {
+		t.Errorf("Invalid commands, got %q, want %q", s.cmds, want)
+	}
+}
+
+func TestSendChunking(t *testing.T) {
+	l := listenTest(t, "tcp", "127.0.0.1:0")
+	done := serveESMTP(l, []string{"CHUNKING"}, nil)
+
+	m, err := NewRawMessage([]byte("From: " + testFrom + "\nTo: " + testTo1 + "\n\n.\nBody\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := testNetworkDialer(l)
+	if err := d.DialAndSendRaw(m); err != nil {
+		t.Fatalf("DialAndSendRaw(): %v", err)
+	}
+
+	// Line endings are converted but the message is not dot-stuffed.
+	data := "From: " + testFrom + "\r\nTo: " + testTo1 + "\r\n\r\n.\r\nBody\r\n"
+
+	s := <-done
+	want := []string{
+		"EHLO localhost",
+		"MAIL FROM:<" + testFrom + ">",
+		"RCPT TO:<" + testTo1 + ">",
+		"BDAT " + strconv.Itoa(len(data)) + " LAST",
+		"QUIT",
+	}
+	if !reflect.DeepEqual(s.cmds, want) {
+		t.Errorf("Invalid commands, got %q, want %q", s.cmds, want)
+	}
+	if s.data != data {
+		t.Errorf("Invalid message, got %q, want %q", s.data, data)
+	}
+}
+
+func TestBDATWriter(t *testing.T) {
+	var out bytes.Buffer
+	replies := strings.NewReader(strings.Repeat("250 OK\r\n", 2))
+	w := &bdatWriter{text: textproto.NewConn(&testConnRW{replies, &out})}
+
+	msg := strings.Repeat("a", chunkSize-1) + "\n" + strings.Repeat("b", 10)
+	if _, err := io.WriteString(w, msg); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "BDAT " + strconv.Itoa(chunkSize+1) + "\r\n" + strings.Repeat("a", chunkSize-1) + "\r\n" +
+		"BDAT 10 LAST\r\n" + strings.Repeat("b", 10)
+	if got := out.String(); got != want {
+		t.Errorf("Invalid chunks, got %d bytes, want %d bytes", len(got), len(want))
+	}
+}
+
+type testConnRW struct {
+	io.Reader
+	io.Writer
+}
+
+func (c *testConnRW) Close() error {
+	return nil
+}
+
+type esmtpSession struct {
+	cmds []string
+	// pipelined is the number of commands received with MAIL.
+	pipelined int
+	data      string
+}
+
+// serveESMTP runs a fake ESMTP server advertising ext on l and returns the
+// session once the client disconnects. Recipients in rcpt are rejected with
+// the given replies.
+func serveESMTP(l net.Listener, ext []string, rcpt map[string]string) <-chan *esmtpSession {
+	done := make(chan *esmtpSession, 1)
+
+	serveTest(l, func(c *textproto.Conn) {
+		s := &esmtpSession{}
+		defer func() { done <- s }()
+
+		for {
+			line, err := c.ReadLine()
+			if err != nil {
+				return
+			}
+			s.cmds = append(s.cmds, line)
+
+			if strings.HasPrefix(line, "MAIL") {
+				b, _ := c.R.Peek(c.R.Buffered())
+				s.pipelined = 1 + bytes.Count(b, []byte("\r\n"))
+			}
+
+			switch {
+			case strings.HasPrefix(line, "EHLO "):
+				c.PrintfLine("250-localhost")
+				for _, e := range ext {
+					c.PrintfLine("250-%s", e)
+				}
+				c.PrintfLine("250 HELP")
+			case strings.HasPrefix(line, "RCPT TO:"):
+				addr := strings.Trim(strings.TrimPrefix(line, "RCPT TO:"), "<>")
+				if reply, ok := rcpt[addr]; ok {
+					c.PrintfLine("%s", reply)
+					continue
+				}
+				c.PrintfLine("250 2.1.5 OK")
+			case line == "DATA":
+				c.PrintfLine("354 Go ahead")
+				b, err := c.ReadDotBytes()
+				if err != nil {
+					return
+				}
+				s.data = strings.Replace(string(b), "\n", "\r\n", -1)
+				c.PrintfLine("250 2.0.0 OK")
+			case strings.HasPrefix(line, "BDAT "):
+				n, _ := strconv.Atoi(strings.Fields(line)[1])
+				b := make([]byte, n)
+				if _, err := io.ReadFull(c.R, b); err != nil {
+					return
+				}
+				s.data += string(b)
+				c.PrintfLine("250 2.0.0 OK")
+			case line == "QUIT":
+				c.PrintfLine("221 2.0.0 Bye")
+				return
+			default:
+				c.PrintfLine("250 2.0.0 OK")
+			}
+		}
+	})
+
+	return done
+}
@@ -0,0 +1,148 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	stdmail "net/mail"
+	"strings"
+)
+
+// A RawMessage is a pre-built RFC 5322 message. It is sent as is, except that
+// its Bcc fields are removed.
+type RawMessage struct {
+	data     []byte
+	envelope *Message
+}
+
+// NewRawMessage parses the header of a pre-built message to derive its
+// envelope. The message itself is not modified.
+func NewRawMessage(data []byte) (*RawMessage, error) {
+	msg, err := stdmail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("gomail: invalid raw message: %v", err)
+	}
+
+	envelope := &Message{header: make(header)}
+
+	for _, field := range []string{"Sender", "From"} {
+		if v := msg.Header.Get(field); v != "" {
+			envelope.header[field] = []string{v}
+		}
+	}
+
+	for _, field := range []string{"To", "Cc", "Bcc"} {
+		if len(msg.Header[field]) == 0 {
+			continue
+		}
+		list, err := msg.Header.AddressList(field)
+		if err != nil {
+			return nil, fmt.Errorf("gomail: invalid %q field: %v", field, err)
+		}
+		for _, a := range list {
+			envelope.header[field] = append(envelope.header[field], a.Address)
+		}
+	}
+
+	return &RawMessage{data: stripField(data, "Bcc"), envelope: envelope}, nil
+}
+
+// Envelope returns the envelope sender and recipients of the message. They are
+// derived from the Sender or From field and from the To, Cc and Bcc fields, as
+// for a Message.
+func (m *RawMessage) Envelope() (string, []string, error) {
+	from, err := m.envelope.getFrom()
+	if err != nil {
+		return "", nil, err
+	}
+
+	to, err := m.envelope.getRecipients()
+	if err != nil {
+		return "", nil, err
+	}
+
+	return from, to, nil
+}
+
+// WriteTo implements io.WriterTo. It writes the message without its Bcc fields.
+func (m *RawMessage) WriteTo(w io.Writer) (int64, error) {
+	n, err := w.Write(m.data)
+
+	return int64(n), err
+}
+
+// SendRaw sends a pre-built message using the given Sender. The recipients are
+// derived from the message unless to is given.
+func SendRaw(s Sender, m *RawMessage, to ...string) error {
+	return SendRawContext(context.Background(), s, m, to...)
+}
+
+// SendRawContext is like SendRaw but aborts when ctx is done if s is a
+// ContextSender.
+func SendRawContext(ctx context.Context, s Sender, m *RawMessage, to ...string) error {
+	from, recipients, err := m.Envelope()
+	if err != nil {
+		return err
+	}
+
+	if len(to) != 0 {
+		recipients = to
+	}
+
+	if len(recipients) == 0 {
+		return errors.New("gomail: invalid message, no recipients")
+	}
+
+	return sendTo(ctx, s, from, recipients, m)
+}
+
+// DialAndSendRaw opens a connection to the SMTP server, sends a pre-built
+// message and closes the connection.
+func (d *Dialer) DialAndSendRaw(m *RawMessage, to ...string) error {
+	return d.DialAndSendRawContext(context.Background(), m, to...)
+}
+
+// DialAndSendRawContext is like DialAndSendRaw but aborts when ctx is done.
+func (d *Dialer) DialAndSendRawContext(ctx context.Context, m *RawMessage, to ...string) error {
+	s, err := d.DialContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	return SendRawContext(ctx, s, m, to...)
+}
+
+// stripField removes every instance of the named field, including its
+// continuation lines, from the header of msg. Line endings are preserved.
+func stripField(msg []byte, name string) []byte {
+	var out []byte
+	skip := false
+
+	for len(msg) > 0 {
+		end := bytes.IndexByte(msg, '\n') + 1
+		if end == 0 {
+			end = len(msg)
+		}
+		line := msg[:end]
+
+		if len(bytes.TrimRight(line, "\r\n")) == 0 {
+			// End of the header, keep the body as is.
+			return append(out, msg...)
+		}
+
+		if line[0] != ' ' && line[0] != '\t' {
+			i := bytes.IndexByte(line, ':')
+			skip = i != -1 && strings.EqualFold(strings.TrimRight(string(line[:i]), " \t"), name)
+		}
+
+		if !skip {
+			out = append(out, line...)
+		}
+		msg = msg[end:]
+	}
+
+	return out
+}
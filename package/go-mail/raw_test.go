@@ -0,0 +1,100 @@
+package mail
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+)
+
+const testRawMsg = "From: =?UTF-8?q?Se=C3=B1or_From?= <from@example.com>\n" +
+	"To: to1@example.com, \"To 2\" <to2@example.com>\n" +
+	"Bcc: bcc1@example.com,\n" +
+	" bcc2@example.com\n" +
+	"Cc: to1@example.com\n" +
+	"Subject: Bcc: test\n" +
+	"\n" +
+	"Bcc: body@example.com\n"
+
+func TestRawMessage(t *testing.T) {
+	m, err := NewRawMessage([]byte(testRawMsg))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	from, to, err := m.Envelope()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if from != "from@example.com" {
+		t.Errorf("Invalid from, got %q", from)
+	}
+	want := []string{"to1@example.com", "to2@example.com", "bcc1@example.com", "bcc2@example.com"}
+	if !reflect.DeepEqual(to, want) {
+		t.Errorf("Invalid recipients, got %v, want %v", to, want)
+	}
+
+	var buf bytes.Buffer
+	n, err := m.WriteTo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(buf.Len()) {
+		t.Errorf("Invalid length, got %d, want %d", n, buf.Len())
+	}
+
+	wantMsg := "From: =?UTF-8?q?Se=C3=B1or_From?= <from@example.com>\n" +
+		"To: to1@example.com, \"To 2\" <to2@example.com>\n" +
+		"Cc: to1@example.com\n" +
+		"Subject: Bcc: test\n" +
+		"\n" +
+		"Bcc: body@example.com\n"
+	if buf.String() != wantMsg {
+		t.Errorf("Invalid message, got %q, want %q", buf.String(), wantMsg)
+	}
+}
+
+func TestRawMessageSender(t *testing.T) {
+	m, err := NewRawMessage([]byte("From: from@example.com\r\nSender: sender@example.com\r\nTo: to@example.com\r\n\r\nTest"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := stubSend(t, "sender@example.com", []string{"other@example.com"},
+		"From: from@example.com\r\nSender: sender@example.com\r\nTo: to@example.com\r\n\r\nTest")
+	if err := SendRaw(s, m, "other@example.com"); err != nil {
+		t.Errorf("SendRaw(): %v", err)
+	}
+}
+
+func TestRawMessageError(t *testing.T) {
+	if _, err := NewRawMessage([]byte("To: invalid\r\n\r\nTest")); err == nil {
+		t.Error("NewRawMessage() should fail on an invalid address")
+	}
+
+	m, err := NewRawMessage([]byte("To: to@example.com\r\n\r\nTest"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := SendRaw(SendFunc(func(string, []string, io.WriterTo) error { return nil }), m); err == nil {
+		t.Error("SendRaw() should fail without a From field")
+	}
+
+	m, err = NewRawMessage([]byte("From: from@example.com\r\n\r\nTest"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := SendRaw(SendFunc(func(string, []string, io.WriterTo) error { return nil }), m); err == nil {
+		t.Error("SendRaw() should fail without recipients")
+	}
+
+	m, err = NewRawMessage([]byte("From: from@example.com\r\nTo: to@example.com\r\n\r\nTest"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	kaboom := errors.New("kaboom")
+	if err := SendRaw(SendFunc(func(string, []string, io.WriterTo) error { return kaboom }), m); err != kaboom {
+		t.Errorf("SendRaw() error, got %v, want %v", err, kaboom)
+	}
+}
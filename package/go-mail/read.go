@@ -0,0 +1,252 @@
+package mail
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	stdmail "net/mail"
+	"net/textproto"
+	"strings"
+
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+// ReadMessage parses an RFC 5322 message so that it can be modified and
+// written again.
+//
+// Text parts that are not attachments become the body and the alternatives of
+// the message. They are decoded to UTF-8, which becomes the charset of the
+// message, and keep their transfer encoding. Other parts become attachments,
+// or embedded files if they are inline or have a Content-ID. Their content is
+// decoded and their MIME header is kept. Header values are kept in their
+// encoded form, as set by SetHeader. The settings are applied to the message
+// before it is parsed.
+func ReadMessage(r io.Reader, settings ...MessageSetting) (*Message, error) {
+	msg, err := stdmail.ReadMessage(r)
+	if err != nil {
+		return nil, fmt.Errorf("gomail: invalid message: %v", err)
+	}
+
+	m := NewMessage(settings...)
+	m.charset = "UTF-8"
+
+	content := make(textproto.MIMEHeader)
+
+	for k, v := range msg.Header {
+		if strings.HasPrefix(k, "Content-") {
+			content[k] = v
+			continue
+		}
+
+		k = canonicalField(k)
+		if addressFields[k] {
+			var list []string
+			for _, item := range v {
+				list = append(list, splitAddressList(item)...)
+			}
+			v = list
+		}
+		m.header[k] = v
+	}
+
+	if err := m.readEntity(content, msg.Body); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+var addressFields = map[string]bool{
+	"From":     true,
+	"Sender":   true,
+	"Reply-To": true,
+	"To":       true,
+	"Cc":       true,
+	"Bcc":      true,
+}
+
+// fieldNames maps the canonical MIME form of common fields to the form used by
+// this package.
+var fieldNames = map[string]string{
+	"Content-Id":     "Content-ID",
+	"Dkim-Signature": "DKIM-Signature",
+	"Message-Id":     "Message-ID",
+	"Mime-Version":   "MIME-Version",
+}
+
+func canonicalField(name string) string {
+	if v, ok := fieldNames[name]; ok {
+		return v
+	}
+
+	return name
+}
+
+var wordDecoder = &mime.WordDecoder{CharsetReader: charsetReader}
+
+func (m *Message) readEntity(h textproto.MIMEHeader, body io.Reader) error {
+	mediaType, params, err := mime.ParseMediaType(h.Get("Content-Type"))
+	if err != nil {
+		mediaType, params = "text/plain", map[string]string{}
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		r := multipart.NewReader(body, params["boundary"])
+		for {
+			p, err := r.NextRawPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("gomail: invalid multipart entity: %v", err)
+			}
+			if err := m.readEntity(p.Header, p); err != nil {
+				return err
+			}
+		}
+	}
+
+	encoding := strings.ToLower(strings.TrimSpace(h.Get("Content-Transfer-Encoding")))
+
+	data, err := io.ReadAll(decodeTransfer(encoding, body))
+	if err != nil {
+		return fmt.Errorf("gomail: invalid %s entity: %v", mediaType, err)
+	}
+
+	disposition, dparams, _ := mime.ParseMediaType(h.Get("Content-Disposition"))
+
+	name := dparams["filename"]
+	if name == "" {
+		name = params["name"]
+	}
+	if dec, err := wordDecoder.DecodeHeader(name); err == nil {
+		name = dec
+	}
+
+	if strings.HasPrefix(mediaType, "text/") && disposition != "attachment" && name == "" {
+		text, err := decodeCharset(params["charset"], data)
+		if err != nil {
+			return err
+		}
+		delete(params, "charset")
+
+		p := &part{
+			contentType: mime.FormatMediaType(mediaType, params),
+			copier:      newCopier(text),
+			encoding:    Unencoded,
+		}
+		switch encoding {
+		case "quoted-printable":
+			p.encoding = QuotedPrintable
+		case "base64":
+			p.encoding = Base64
+		}
+		m.parts = append(m.parts, p)
+
+		return nil
+	}
+
+	f := &file{
+		Name:   name,
+		Header: make(map[string][]string),
+		CopyFunc: func(w io.Writer) error {
+			_, err := w.Write(data)
+			return err
+		},
+	}
+	for k, v := range h {
+		f.Header[canonicalField(k)] = v
+	}
+	f.setHeader("Content-Transfer-Encoding", string(Base64))
+
+	if disposition == "inline" || (disposition == "" && h.Get("Content-Id") != "") {
+		m.embedded = append(m.embedded, f)
+	} else {
+		m.attachments = append(m.attachments, f)
+	}
+
+	return nil
+}
+
+func decodeTransfer(encoding string, r io.Reader) io.Reader {
+	switch encoding {
+	case "quoted-printable":
+		return quotedprintable.NewReader(r)
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, r)
+	}
+
+	return r
+}
+
+func decodeCharset(charset string, data []byte) (string, error) {
+	r, err := charsetReader(charset, bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+
+	text, err := io.ReadAll(r)
+	if err != nil {
+		return "", fmt.Errorf("gomail: invalid %s text: %v", charset, err)
+	}
+
+	return string(text), nil
+}
+
+func charsetReader(charset string, input io.Reader) (io.Reader, error) {
+	switch strings.ToLower(charset) {
+	case "", "utf-8", "us-ascii":
+		return input, nil
+	}
+
+	enc, err := htmlindex.Get(charset)
+	if err != nil {
+		return nil, fmt.Errorf("gomail: unsupported charset %q", charset)
+	}
+
+	return enc.NewDecoder().Reader(input), nil
+}
+
+// splitAddressList splits an address list field at the commas that are not
+// quoted, commented or inside angle brackets.
+func splitAddressList(s string) []string {
+	var list []string
+	var quoted, escaped bool
+	depth, angle, start := 0, 0, 0
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case escaped:
+			escaped = false
+		case c == '\\':
+			escaped = true
+		case c == '"':
+			quoted = !quoted
+		case quoted:
+		case c == '(':
+			depth++
+		case c == ')' && depth > 0:
+			depth--
+		case c == '<':
+			angle++
+		case c == '>' && angle > 0:
+			angle--
+		case c == ',' && depth == 0 && angle == 0:
+			if item := strings.TrimSpace(s[start:i]); item != "" {
+				list = append(list, item)
+			}
+			start = i + 1
+		}
+	}
+
+	if item := strings.TrimSpace(s[start:]); item != "" {
+		list = append(list, item)
+	}
+
+	return list
+}
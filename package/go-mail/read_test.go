@@ -0,0 +1,146 @@
+package mail
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadMessage(t *testing.T) {
+	m := NewMessage()
+	m.SetAddressHeader("From", "from@example.com", "Señor From")
+	m.SetHeader("To", "to1@example.com", "\"To, 2\" <to2@example.com>")
+	m.SetHeader("Cc", "cc@example.com")
+	m.SetHeader("Subject", "¡Hola, señor! "+strings.Repeat("long subject ", 10))
+	m.SetHeader("Message-ID", "<123@example.com>")
+	m.SetHeader("X-Mailer", "gomail")
+	m.SetBody("text/plain", "¡Hola, señor!\r\n")
+	m.AddAlternative("text/html", "<p>¡Hola, señor!</p>", SetPartEncoding(Base64))
+	m.Attach(mockCopyFile("test.pdf"))
+	m.Attach(mockCopyFile("señor.txt"))
+	m.Embed(mockCopyFile("image.jpg"))
+
+	var want bytes.Buffer
+	if _, err := m.WriteTo(&want); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := ReadMessage(bytes.NewReader(want.Bytes()))
+	if err != nil {
+		t.Fatalf("ReadMessage(): %v", err)
+	}
+
+	for _, field := range []string{"From", "To", "Cc", "Subject", "Message-ID", "X-Mailer"} {
+		if got := r.GetHeader(field); !reflect.DeepEqual(got, m.GetHeader(field)) {
+			t.Errorf("Invalid %s header, got %q, want %q", field, got, m.GetHeader(field))
+		}
+	}
+
+	if len(r.parts) != 2 || len(r.attachments) != 2 || len(r.embedded) != 1 {
+		t.Fatalf("Invalid structure, got %d parts, %d attachments and %d embedded files",
+			len(r.parts), len(r.attachments), len(r.embedded))
+	}
+
+	if r.attachments[1].Name != "señor.txt" {
+		t.Errorf("Invalid attachment name %q", r.attachments[1].Name)
+	}
+
+	var got bytes.Buffer
+	if _, err := r.WriteTo(&got); err != nil {
+		t.Fatal(err)
+	}
+
+	wantMsg := want.String()
+	gotBoundaries := getBoundaries(t, 3, got.String())
+	for i, b := range getBoundaries(t, 3, wantMsg) {
+		wantMsg = strings.Replace(wantMsg, b, gotBoundaries[i], -1)
+	}
+
+	compareBodies(t, got.String(), wantMsg)
+}
+
+func TestReadMessageEncodings(t *testing.T) {
+	msg := "From: from@example.com\n" +
+		"To: to@example.com\n" +
+		"Mime-Version: 1.0\n" +
+		"Content-Type: multipart/mixed; boundary=outer\n" +
+		"\n" +
+		"--outer\n" +
+		"Content-Type: text/plain; charset=ISO-8859-1; format=flowed\n" +
+		"Content-Transfer-Encoding: quoted-printable\n" +
+		"\n" +
+		"Se=F1or\n" +
+		"--outer\n" +
+		"Content-Type: image/png\n" +
+		"Content-ID: <logo>\n" +
+		"Content-Transfer-Encoding: base64\n" +
+		"\n" +
+		"bG9nbw==\n" +
+		"--outer\n" +
+		"Content-Type: text/plain; name=\"=?UTF-8?q?se=C3=B1or.txt?=\"\n" +
+		"Content-Disposition: attachment\n" +
+		"\n" +
+		"attached\n" +
+		"--outer--\n"
+
+	m, err := ReadMessage(strings.NewReader(msg))
+	if err != nil {
+		t.Fatalf("ReadMessage(): %v", err)
+	}
+
+	if len(m.header["MIME-Version"]) != 1 {
+		t.Error("MIME-Version header should be canonicalized")
+	}
+
+	if len(m.parts) != 1 || m.parts[0].contentType != "text/plain; format=flowed" || m.parts[0].encoding != QuotedPrintable {
+		t.Fatalf("Invalid parts: %+v", m.parts)
+	}
+
+	var buf bytes.Buffer
+	if err := m.parts[0].copier(&buf); err != nil || buf.String() != "Señor" {
+		t.Errorf("Invalid body %q", buf.String())
+	}
+
+	if len(m.embedded) != 1 || m.embedded[0].Header["Content-ID"][0] != "<logo>" {
+		t.Fatalf("Invalid embedded files: %+v", m.embedded)
+	}
+
+	buf.Reset()
+	if err := m.embedded[0].CopyFunc(&buf); err != nil || buf.String() != "logo" {
+		t.Errorf("Invalid embedded content %q", buf.String())
+	}
+
+	if len(m.attachments) != 1 || m.attachments[0].Name != "señor.txt" ||
+		m.attachments[0].Header["Content-Transfer-Encoding"][0] != "base64" {
+		t.Fatalf("Invalid attachments: %+v", m.attachments)
+	}
+
+	buf.Reset()
+	if err := m.attachments[0].CopyFunc(&buf); err != nil || buf.String() != "attached" {
+		t.Errorf("Invalid attachment content %q", buf.String())
+	}
+}
+
+func TestReadMessageError(t *testing.T) {
+	tests := []string{
+		"invalid",
+		"From: from@example.com\r\nContent-Type: text/plain; charset=x-unknown\r\n\r\nTest",
+		"From: from@example.com\r\nContent-Type: multipart/mixed; boundary=b\r\n\r\n--b\r\nTest",
+	}
+
+	for _, msg := range tests {
+		if _, err := ReadMessage(strings.NewReader(msg)); err == nil {
+			t.Errorf("ReadMessage(%q) should fail", msg)
+		}
+	}
+}
+
+func TestSplitAddressList(t *testing.T) {
+	got := splitAddressList(`a@example.com, "B, b" <b@example.com>,c@example.com (C, c), <d,@example.com>,`)
+	want := []string{"a@example.com", `"B, b" <b@example.com>`, "c@example.com (C, c)", "<d,@example.com>"}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Invalid list, got %q, want %q", got, want)
+	}
+}
@@ -1,6 +1,7 @@
 package mail
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -22,6 +23,13 @@ type SendCloser interface {
 	Close() error
 }
 
+// ContextSender is implemented by senders that can abort sending an email when
+// a context is done.
+type ContextSender interface {
+	Sender
+	SendContext(ctx context.Context, from string, to []string, msg io.WriterTo) error
+}
+
 // A SendFunc is a function that sends emails to the given addresses.
 //
 // The SendFunc type is an adapter to allow the use of ordinary functions as
@@ -36,8 +44,14 @@ func (f SendFunc) Send(from string, to []string, msg io.WriterTo) error {
 
 // Send sends emails using the given Sender.
 func Send(s Sender, msg ...*Message) error {
+	return SendContext(context.Background(), s, msg...)
+}
+
+// SendContext is like Send but stops when ctx is done. The email being sent is
+// aborted if s is a ContextSender.
+func SendContext(ctx context.Context, s Sender, msg ...*Message) error {
 	for i, m := range msg {
-		if err := send(s, m); err != nil {
+		if err := send(ctx, s, m); err != nil {
 			return &SendError{Cause: err, Index: uint(i)}
 		}
 	}
@@ -45,7 +59,7 @@ func Send(s Sender, msg ...*Message) error {
 	return nil
 }
 
-func send(s Sender, m *Message) error {
+func send(ctx context.Context, s Sender, m *Message) error {
 	from, err := m.getFrom()
 	if err != nil {
 		return err
@@ -56,11 +70,19 @@ func send(s Sender, m *Message) error {
 		return err
 	}
 
-	if err := s.Send(from, to, m); err != nil {
+	return sendTo(ctx, s, from, to, m)
+}
+
+func sendTo(ctx context.Context, s Sender, from string, to []string, msg io.WriterTo) error {
+	if err := ctx.Err(); err != nil {
 		return err
 	}
 
-	return nil
+	if cs, ok := s.(ContextSender); ok {
+		return cs.SendContext(ctx, from, to, msg)
+	}
+
+	return s.Send(from, to, msg)
 }
 
 func (m *Message) getFrom() (string, error) {
@@ -0,0 +1,134 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+)
+
+// DefaultSendmailPath is the sendmail program used when SendmailSender.Path is
+// empty.
+const DefaultSendmailPath = "/usr/sbin/sendmail"
+
+// A SendmailSender sends emails by piping them to a sendmail compatible program,
+// such as the one installed by Postfix, Exim or msmtp.
+//
+// The program is run as "Path Args... -f from". With -t, the program reads the
+// recipients from the message, whose Bcc field is written for it to remove.
+// Otherwise the recipients are given as "-- to...".
+type SendmailSender struct {
+	// Path is the path of the program, it defaults to DefaultSendmailPath.
+	Path string
+	// Args are the options given to the program, they default to -t and -i so
+	// that a line with a single dot does not end the message.
+	Args []string
+}
+
+// Send implements Sender.
+func (s *SendmailSender) Send(from string, to []string, msg io.WriterTo) error {
+	return s.SendContext(context.Background(), from, to, msg)
+}
+
+// SendContext implements ContextSender. The program is killed if ctx is done
+// before it exits.
+func (s *SendmailSender) SendContext(ctx context.Context, from string, to []string, msg io.WriterTo) error {
+	path := s.Path
+	if path == "" {
+		path = DefaultSendmailPath
+	}
+
+	args := s.Args
+	if args == nil {
+		args = []string{"-t", "-i"}
+	}
+	extract := hasArg(args, "-t")
+	args = append(append([]string{}, args...), "-f", from)
+	if !extract {
+		args = append(append(args, "--"), to...)
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, path, args...)
+	cmd.Stderr = &stderr
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("gomail: %s failed: %v", path, err)
+	}
+
+	werr := pipeMessage(stdin, msg, extract)
+	if cerr := stdin.Close(); werr == nil {
+		werr = cerr
+	}
+
+	// An early exit of the program explains a failed write best.
+	if err := cmd.Wait(); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if out := strings.TrimSpace(stderr.String()); out != "" {
+			return fmt.Errorf("gomail: %s failed: %v: %s", path, err, out)
+		}
+		return fmt.Errorf("gomail: %s failed: %v", path, err)
+	}
+
+	return werr
+}
+
+// pipeMessage streams msg to w. With extract, the Bcc field left out by
+// WriteTo is written first, so that the program delivers to Bcc recipients.
+func pipeMessage(w io.Writer, msg io.WriterTo, extract bool) error {
+	if extract {
+		if bcc := bccRecipients(msg); len(bcc) != 0 {
+			if _, err := io.WriteString(w, "Bcc: "+strings.Join(bcc, ", ")+"\r\n"); err != nil {
+				return err
+			}
+		}
+	}
+
+	_, err := msg.WriteTo(w)
+
+	return err
+}
+
+func bccRecipients(msg io.WriterTo) []string {
+	var h header
+
+	switch m := msg.(type) {
+	case *Message:
+		h = m.header
+	case *RawMessage:
+		h = m.envelope.header
+	}
+
+	var list []string
+	for _, a := range h["Bcc"] {
+		if addr, err := parseAddress(a); err == nil {
+			list = addAddress(list, addr)
+		}
+	}
+
+	return list
+}
+
+func hasArg(args []string, arg string) bool {
+	for _, a := range args {
+		if a == arg {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Close implements SendCloser. It does nothing.
+func (s *SendmailSender) Close() error {
+	return nil
+}
@@ -0,0 +1,135 @@
+package mail
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestSendmailSender(t *testing.T) {
+	dir := t.TempDir()
+	s := &SendmailSender{Path: writeSendmail(t, dir, "exit 0")}
+
+	if err := Send(s, getTestMessage()); err != nil {
+		t.Fatalf("Send(): %v", err)
+	}
+
+	args, err := os.ReadFile(filepath.Join(dir, "args"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "-t -i -f " + testFrom + "\n"; string(args) != want {
+		t.Errorf("Invalid arguments, got %q, want %q", args, want)
+	}
+
+	msg, err := os.ReadFile(filepath.Join(dir, "stdin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareBodies(t, string(msg), testMsg)
+
+	s.Args = []string{"-oi", "-odq"}
+	if err := Send(s, getTestMessage()); err != nil {
+		t.Fatalf("Send(): %v", err)
+	}
+
+	args, err = os.ReadFile(filepath.Join(dir, "args"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "-oi -odq -f " + testFrom + " -- " + testTo1 + " " + testTo2 + "\n"; string(args) != want {
+		t.Errorf("Invalid arguments, got %q, want %q", args, want)
+	}
+}
+
+func TestSendmailSenderBcc(t *testing.T) {
+	dir := t.TempDir()
+	s := &SendmailSender{Path: writeSendmail(t, dir, "exit 0")}
+
+	m := getTestMessage()
+	m.SetHeader("Bcc", "Bob <bob@example.com>", "catherine@example.com")
+
+	if err := Send(s, m); err != nil {
+		t.Fatalf("Send(): %v", err)
+	}
+
+	msg, err := os.ReadFile(filepath.Join(dir, "stdin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(msg), "Bcc: bob@example.com, catherine@example.com\r\n") {
+		t.Errorf("Bcc recipients missing from the input, got %q", msg)
+	}
+
+	r, err := NewRawMessage([]byte("From: " + testFrom + "\r\nTo: " + testTo1 + "\r\nBcc: bob@example.com\r\n\r\nTest\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := SendRaw(s, r); err != nil {
+		t.Fatalf("SendRaw(): %v", err)
+	}
+
+	msg, err = os.ReadFile(filepath.Join(dir, "stdin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "Bcc: bob@example.com\r\nFrom: " + testFrom + "\r\nTo: " + testTo1 + "\r\n\r\nTest\r\n"; string(msg) != want {
+		t.Errorf("Invalid input, got %q, want %q", msg, want)
+	}
+}
+
+func TestSendmailSenderError(t *testing.T) {
+	dir := t.TempDir()
+
+	s := &SendmailSender{Path: writeSendmail(t, dir, "echo 'User unknown' >&2; exit 67")}
+	if err := Send(s, getTestMessage()); err == nil || !strings.Contains(err.Error(), "User unknown") {
+		t.Errorf("Send() error, got %v", err)
+	}
+
+	// The program exits without reading a message larger than the pipe buffer.
+	s = &SendmailSender{Path: filepath.Join(dir, "reject")}
+	if err := os.WriteFile(s.Path, []byte("#!/bin/sh\necho 'Permission denied' >&2\nexit 77\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	m := getTestMessage()
+	m.SetBody("text/plain", strings.Repeat("0123456789\n", 100000))
+	if err := Send(s, m); err == nil || !strings.Contains(err.Error(), "Permission denied") {
+		t.Errorf("Send() error, got %v", err)
+	}
+
+	s = &SendmailSender{Path: writeSendmail(t, dir, "sleep 10")}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := SendContext(ctx, s, getTestMessage()); !errors.Is(err, context.Canceled) {
+		t.Errorf("SendContext() error, got %v, want %v", err, context.Canceled)
+	}
+
+	s = &SendmailSender{Path: filepath.Join(dir, "invalid")}
+	if err := Send(s, getTestMessage()); err == nil {
+		t.Error("Send() should fail with an invalid path")
+	}
+}
+
+// writeSendmail writes a fake sendmail program that records its arguments and
+// input in dir before running script.
+func writeSendmail(t *testing.T, dir, script string) string {
+	if runtime.GOOS == "windows" {
+		t.Skip("sendmail is not available on Windows")
+	}
+
+	name := filepath.Join(dir, "sendmail")
+	data := "#!/bin/sh\n" +
+		"echo \"$@\" > '" + filepath.Join(dir, "args") + "'\n" +
+		"cat > '" + filepath.Join(dir, "stdin") + "'\n" +
+		script + "\n"
+	if err := os.WriteFile(name, []byte(data), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	return name
+}
@@ -0,0 +1,214 @@
+package mail
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"math/big"
+	"mime/multipart"
+
+	"go.mozilla.org/pkcs7"
+)
+
+// SMIME configures S/MIME (RFC 8551) protection of a message. The message is
+// signed if Certificate is set and then encrypted if Recipients is not empty.
+type SMIME struct {
+	// Certificate is the signer certificate.
+	Certificate *x509.Certificate
+	// PrivateKey is the private key of the signer certificate.
+	PrivateKey crypto.PrivateKey
+	// Chain holds the intermediate certificates included in the signature.
+	Chain []*x509.Certificate
+	// Recipients holds the certificates the message is encrypted to.
+	Recipients []*x509.Certificate
+}
+
+// SetSMIME is a message setting to sign and/or encrypt the email with S/MIME
+// when it is written.
+func SetSMIME(s *SMIME) MessageSetting {
+	return func(m *Message) {
+		m.smime = s
+	}
+}
+
+func (s *SMIME) wrap(entity []byte) ([]byte, error) {
+	var err error
+
+	if s.Certificate != nil {
+		if entity, err = s.sign(entity); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(s.Recipients) > 0 {
+		if entity, err = s.encrypt(entity); err != nil {
+			return nil, err
+		}
+	}
+
+	return entity, nil
+}
+
+func (s *SMIME) sign(entity []byte) ([]byte, error) {
+	sd, err := pkcs7.NewSignedData(entity)
+	if err != nil {
+		return nil, fmt.Errorf("gomail: S/MIME signing failed: %v", err)
+	}
+
+	sd.SetDigestAlgorithm(pkcs7.OIDDigestAlgorithmSHA256)
+
+	if err := sd.AddSignerChain(s.Certificate, s.PrivateKey, s.Chain, pkcs7.SignerInfoConfig{}); err != nil {
+		return nil, fmt.Errorf("gomail: S/MIME signing failed: %v", err)
+	}
+
+	sd.Detach()
+
+	sig, err := sd.Finish()
+	if err != nil {
+		return nil, fmt.Errorf("gomail: S/MIME signing failed: %v", err)
+	}
+
+	boundary := multipart.NewWriter(io.Discard).Boundary()
+
+	var buf bytes.Buffer
+	buf.WriteString("Content-Type: multipart/signed; protocol=\"application/pkcs7-signature\";\r\n" +
+		" micalg=sha-256; boundary=\"" + boundary + "\"\r\n\r\n")
+	buf.WriteString("This is an S/MIME signed message\r\n\r\n")
+	buf.WriteString("--" + boundary + "\r\n")
+	buf.Write(entity)
+	buf.WriteString("\r\n--" + boundary + "\r\n")
+	writeBase64Entity(&buf, "application/pkcs7-signature", "smime.p7s", sig)
+	buf.WriteString("\r\n--" + boundary + "--\r\n")
+
+	return buf.Bytes(), nil
+}
+
+func (s *SMIME) encrypt(entity []byte) ([]byte, error) {
+	enc, err := envelope(entity, s.Recipients)
+	if err != nil {
+		return nil, fmt.Errorf("gomail: S/MIME encryption failed: %v", err)
+	}
+
+	var buf bytes.Buffer
+	writeBase64Entity(&buf, "application/pkcs7-mime; smime-type=enveloped-data", "smime.p7m", enc)
+
+	return buf.Bytes(), nil
+}
+
+func writeBase64Entity(buf *bytes.Buffer, contentType, name string, data []byte) {
+	buf.WriteString("Content-Type: " + contentType + "; name=\"" + name + "\"\r\n")
+	buf.WriteString("Content-Transfer-Encoding: base64\r\n")
+	buf.WriteString("Content-Disposition: attachment; filename=\"" + name + "\"\r\n\r\n")
+
+	wc := base64.NewEncoder(base64.StdEncoding, newBase64LineWriter(buf))
+	wc.Write(data)
+	wc.Close()
+	buf.WriteString("\r\n")
+}
+
+// The CMS (RFC 5652) structures of enveloped data. pkcs7.Encrypt is not used as
+// it reads the content encryption algorithm from a package variable, which
+// cannot be changed safely while other code may use it.
+type envelopedContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,optional,tag:0"`
+}
+
+type envelopedData struct {
+	Version              int
+	RecipientInfos       []keyTransRecipientInfo `asn1:"set"`
+	EncryptedContentInfo encryptedContentInfo
+}
+
+type keyTransRecipientInfo struct {
+	Version                int
+	IssuerAndSerialNumber  issuerAndSerialNumber
+	KeyEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedKey           []byte
+}
+
+type issuerAndSerialNumber struct {
+	Issuer       asn1.RawValue
+	SerialNumber *big.Int
+}
+
+type encryptedContentInfo struct {
+	ContentType                asn1.ObjectIdentifier
+	ContentEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedContent           asn1.RawValue `asn1:"tag:0,optional"`
+}
+
+// envelope encrypts the content with AES-256-CBC and the content key to each
+// RSA recipient.
+func envelope(content []byte, recipients []*x509.Certificate) ([]byte, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+
+	iv := make([]byte, aes.BlockSize)
+	if _, err := rand.Read(iv); err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	padding := aes.BlockSize - len(content)%aes.BlockSize
+	plaintext := append(append([]byte{}, content...), bytes.Repeat([]byte{byte(padding)}, padding)...)
+	ciphertext := make([]byte, len(plaintext))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(ciphertext, plaintext)
+
+	encrypted, err := asn1.Marshal(ciphertext)
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]keyTransRecipientInfo, len(recipients))
+	for i, cert := range recipients {
+		pub, ok := cert.PublicKey.(*rsa.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("unsupported public key of recipient %q", cert.Subject.CommonName)
+		}
+		encryptedKey, err := rsa.EncryptPKCS1v15(rand.Reader, pub, key)
+		if err != nil {
+			return nil, err
+		}
+		infos[i] = keyTransRecipientInfo{
+			IssuerAndSerialNumber:  issuerAndSerialNumber{Issuer: asn1.RawValue{FullBytes: cert.RawIssuer}, SerialNumber: cert.SerialNumber},
+			KeyEncryptionAlgorithm: pkix.AlgorithmIdentifier{Algorithm: pkcs7.OIDEncryptionAlgorithmRSA},
+			EncryptedKey:           encryptedKey,
+		}
+	}
+
+	data, err := asn1.Marshal(envelopedData{
+		RecipientInfos: infos,
+		EncryptedContentInfo: encryptedContentInfo{
+			ContentType: pkcs7.OIDData,
+			ContentEncryptionAlgorithm: pkix.AlgorithmIdentifier{
+				Algorithm:  pkcs7.OIDEncryptionAlgorithmAES256CBC,
+				Parameters: asn1.RawValue{Tag: asn1.TagOctetString, Bytes: iv},
+			},
+			EncryptedContent: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: encrypted},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return asn1.Marshal(envelopedContentInfo{
+		ContentType: pkcs7.OIDEnvelopedData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: data},
+	})
+}
@@ -0,0 +1,280 @@
+package mail
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"io"
+	"math/big"
+	"mime"
+	"mime/multipart"
+	stdmail "net/mail"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mozilla.org/pkcs7"
+)
+
+func TestSMIMESign(t *testing.T) {
+	cert, key := newSMIMECertificate(t, "from@example.com")
+
+	m := NewMessage(SetSMIME(&SMIME{Certificate: cert, PrivateKey: key}))
+	m.SetHeader("From", "from@example.com")
+	m.SetHeader("To", "to@example.com")
+	m.SetHeader("Bcc", "bcc@example.com")
+	m.SetBody("text/plain", "¡Hola, señor!\n")
+	m.AddAlternative("text/html", "<p>¡Hola, señor!</p>")
+
+	msg := writeSMIMEMessage(t, m)
+
+	if msg.Header.Get("Bcc") != "" {
+		t.Error("Bcc header should not be written")
+	}
+
+	entity, sig := splitSMIMESigned(t, msg)
+
+	if !strings.HasPrefix(string(entity), "Content-Type: multipart/alternative;") {
+		t.Errorf("Invalid signed entity: %q", entity)
+	}
+
+	verifySMIMESignature(t, entity, sig)
+}
+
+func TestSMIMEEncrypt(t *testing.T) {
+	cert, key := newSMIMECertificate(t, "to@example.com")
+
+	m := NewMessage(SetSMIME(&SMIME{Recipients: []*x509.Certificate{cert}}))
+	m.SetHeader("From", "from@example.com")
+	m.SetHeader("To", "to@example.com")
+	m.SetBody("text/plain", "Secret")
+
+	entity := decryptSMIME(t, writeSMIMEMessage(t, m), cert, key)
+
+	want := "Content-Type: text/plain; charset=UTF-8\r\n" +
+		"Content-Transfer-Encoding: quoted-printable\r\n" +
+		"\r\n" +
+		"Secret"
+	compareBodies(t, string(entity), want)
+}
+
+func TestSMIMEEncryptAlgorithm(t *testing.T) {
+	cert, key := newSMIMECertificate(t, "to@example.com")
+
+	enc, err := envelope([]byte("Secret"), []*x509.Certificate{cert})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var info envelopedContentInfo
+	var data envelopedData
+	if _, err := asn1.Unmarshal(enc, &info); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := asn1.Unmarshal(info.Content.Bytes, &data); err != nil {
+		t.Fatal(err)
+	}
+	if alg := data.EncryptedContentInfo.ContentEncryptionAlgorithm.Algorithm; !alg.Equal(pkcs7.OIDEncryptionAlgorithmAES256CBC) {
+		t.Errorf("Invalid content encryption algorithm, got %v, want AES-256-CBC", alg)
+	}
+
+	if pkcs7.ContentEncryptionAlgorithm != pkcs7.EncryptionAlgorithmDESCBC {
+		t.Error("The pkcs7 content encryption algorithm should not be changed")
+	}
+
+	p7, err := pkcs7.Parse(enc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := p7.Decrypt(cert, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "Secret" {
+		t.Errorf("Invalid decrypted content, got %q, want %q", content, "Secret")
+	}
+}
+
+func TestSMIMESignAndEncrypt(t *testing.T) {
+	signer, signerKey := newSMIMECertificate(t, "from@example.com")
+	recipient, recipientKey := newSMIMECertificate(t, "to@example.com")
+
+	m := NewMessage(SetSMIME(&SMIME{
+		Certificate: signer,
+		PrivateKey:  signerKey,
+		Recipients:  []*x509.Certificate{recipient},
+	}))
+	m.SetHeader("From", "from@example.com")
+	m.SetHeader("To", "to@example.com")
+	m.SetBody("text/plain", "Secret")
+	m.Attach(mockCopyFile("/tmp/test.pdf"))
+
+	entity := decryptSMIME(t, writeSMIMEMessage(t, m), recipient, recipientKey)
+
+	msg, err := stdmail.ReadMessage(bytes.NewReader(entity))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signed, sig := splitSMIMESigned(t, msg)
+
+	if !strings.HasPrefix(string(signed), "Content-Type: multipart/mixed;") {
+		t.Errorf("Invalid signed entity: %q", signed)
+	}
+
+	verifySMIMESignature(t, signed, sig)
+}
+
+func TestSMIMEError(t *testing.T) {
+	cert, _ := newSMIMECertificate(t, "from@example.com")
+
+	m := NewMessage(SetSMIME(&SMIME{Certificate: cert}))
+	m.SetHeader("From", "from@example.com")
+	m.SetBody("text/plain", "Test")
+
+	if _, err := m.WriteTo(io.Discard); err == nil {
+		t.Error("WriteTo() should fail without a private key")
+	}
+}
+
+func newSMIMECertificate(t *testing.T, address string) (*x509.Certificate, *rsa.PrivateKey) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:   big.NewInt(time.Now().UnixNano()),
+		Subject:        pkix.Name{CommonName: address},
+		EmailAddresses: []string{address},
+		NotBefore:      time.Now().Add(-time.Hour),
+		NotAfter:       time.Now().Add(time.Hour),
+		KeyUsage:       x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageEmailProtection},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cert, key
+}
+
+func writeSMIMEMessage(t *testing.T, m *Message) *stdmail.Message {
+	var buf bytes.Buffer
+	if _, err := m.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo(): %v", err)
+	}
+
+	msg, err := stdmail.ReadMessage(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if msg.Header.Get("MIME-Version") != "1.0" {
+		t.Error("MIME-Version header is absent")
+	}
+
+	return msg
+}
+
+// splitSMIMESigned returns the raw signed entity and the signature of a
+// multipart/signed message.
+func splitSMIMESigned(t *testing.T, msg *stdmail.Message) ([]byte, []byte) {
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mediaType != "multipart/signed" || params["protocol"] != "application/pkcs7-signature" || params["micalg"] != "sha-256" {
+		t.Fatalf("Invalid Content-Type: %q", msg.Header.Get("Content-Type"))
+	}
+
+	body, err := io.ReadAll(msg.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	delimiter := "--" + params["boundary"]
+	start := strings.Index(string(body), delimiter+"\r\n")
+	if start == -1 {
+		t.Fatalf("Invalid multipart/signed body: %q", body)
+	}
+	start += len(delimiter) + 2
+	end := strings.Index(string(body[start:]), "\r\n"+delimiter+"\r\n")
+	if end == -1 {
+		t.Fatalf("Invalid multipart/signed body: %q", body)
+	}
+	entity := body[start : start+end]
+
+	r := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+	if _, err := r.NextPart(); err != nil {
+		t.Fatal(err)
+	}
+	p, err := r.NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Header.Get("Content-Type") != `application/pkcs7-signature; name="smime.p7s"` {
+		t.Errorf("Invalid signature Content-Type: %q", p.Header.Get("Content-Type"))
+	}
+	sig, err := io.ReadAll(base64.NewDecoder(base64.StdEncoding, p))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return entity, sig
+}
+
+func verifySMIMESignature(t *testing.T, entity, sig []byte) {
+	p7, err := pkcs7.Parse(sig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p7.Content = entity
+	if err := p7.Verify(); err != nil {
+		t.Errorf("Verify(): %v", err)
+	}
+
+	p7.Content = append(entity, '!')
+	if err := p7.Verify(); err == nil {
+		t.Error("Verify() should fail on a tampered entity")
+	}
+}
+
+func decryptSMIME(t *testing.T, msg *stdmail.Message, cert *x509.Certificate, key *rsa.PrivateKey) []byte {
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mediaType != "application/pkcs7-mime" || params["smime-type"] != "enveloped-data" {
+		t.Fatalf("Invalid Content-Type: %q", msg.Header.Get("Content-Type"))
+	}
+
+	data, err := io.ReadAll(base64.NewDecoder(base64.StdEncoding, msg.Body))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p7, err := pkcs7.Parse(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entity, err := p7.Decrypt(cert, key)
+	if err != nil {
+		t.Fatalf("Decrypt(): %v", err)
+	}
+
+	return entity
+}
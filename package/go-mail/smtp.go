@@ -1,6 +1,7 @@
 package mail
 
 import (
+	"context"
 	"crypto/tls"
 	"fmt"
 	"io"
@@ -76,14 +77,149 @@ func NewPlainDialer(host string, port int, username, password string) *Dialer {
 // proxy or other special behavior is needed.
 var NetDialTimeout = net.DialTimeout
 
+// NetDialContext, if set, is used by DialContext instead of NetDialTimeout to
+// establish a connection to the SMTP server.
+var NetDialContext func(ctx context.Context, network, address string) (net.Conn, error)
+
 // Dial dials and authenticates to an SMTP server. The returned SendCloser
 // should be closed when done using it.
 func (d *Dialer) Dial() (SendCloser, error) {
-	conn, err := NetDialTimeout("tcp", addr(d.Host, d.Port), d.Timeout)
+	return d.DialContext(context.Background())
+}
+
+// DialContext is like Dial but aborts connecting, the TLS handshake and the
+// authentication when ctx is done. A deadline of ctx also bounds the Timeout
+// of the connection.
+func (d *Dialer) DialContext(ctx context.Context) (SendCloser, error) {
+	conn, err := d.netDial(ctx)
 	if err != nil {
 		return nil, err
 	}
 
+	if deadline := d.deadline(ctx); !deadline.IsZero() {
+		conn.SetDeadline(deadline)
+	}
+
+	stop := watchContext(ctx, conn)
+	s, err := d.dial(conn)
+	if cerr := stop(); cerr != nil {
+		conn.Close()
+		return nil, cerr
+	}
+	if err != nil {
+		if cerr := contextError(ctx); cerr != nil {
+			conn.Close()
+			return nil, cerr
+		}
+		return s, err
+	}
+
+	// The deadline of ctx only bounds dialing, as with Dial the connection
+	// keeps the Timeout.
+	conn.SetDeadline(d.deadline(context.Background()))
+
+	return s, nil
+}
+
+func (d *Dialer) netDial(ctx context.Context) (net.Conn, error) {
+	address := addr(d.Host, d.Port)
+
+	if NetDialContext != nil {
+		return NetDialContext(ctx, "tcp", address)
+	}
+
+	if ctx.Done() == nil {
+		return NetDialTimeout("tcp", address, d.Timeout)
+	}
+
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+
+	// NetDialTimeout may be overridden, so dial in the background and give up
+	// on the connection if ctx is done first.
+	ch := make(chan result, 1)
+	go func() {
+		conn, err := NetDialTimeout("tcp", address, d.Timeout)
+		ch <- result{conn, err}
+	}()
+
+	select {
+	case r := <-ch:
+		return r.conn, r.err
+	case <-ctx.Done():
+		go func() {
+			if r := <-ch; r.conn != nil {
+				r.conn.Close()
+			}
+		}()
+		return nil, ctx.Err()
+	}
+}
+
+// deadline returns the earliest of the Timeout from now and the deadline of
+// ctx, or the zero time if there is none.
+func (d *Dialer) deadline(ctx context.Context) time.Time {
+	return timeoutDeadline(ctx, d.Timeout)
+}
+
+func timeoutDeadline(ctx context.Context, timeout time.Duration) time.Time {
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+
+	if t, ok := ctx.Deadline(); ok && (deadline.IsZero() || t.Before(deadline)) {
+		deadline = t
+	}
+
+	return deadline
+}
+
+// watchContext interrupts any blocking I/O on conn when ctx is done. The
+// returned function stops watching and returns the error of ctx if conn was
+// interrupted, in which case conn cannot be used anymore.
+func watchContext(ctx context.Context, conn net.Conn) func() error {
+	if ctx.Done() == nil {
+		return func() error { return nil }
+	}
+
+	done := make(chan struct{})
+	finished := make(chan error, 1)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Unix(1, 0))
+			finished <- ctx.Err()
+		case <-done:
+			finished <- nil
+		}
+	}()
+
+	return func() error {
+		close(done)
+		return <-finished
+	}
+}
+
+// contextError returns the error of ctx, or DeadlineExceeded if its deadline
+// has passed but ctx is not done yet, as when I/O bounded by the same deadline
+// fails first.
+func contextError(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if t, ok := ctx.Deadline(); ok && !time.Now().Before(t) {
+		return context.DeadlineExceeded
+	}
+
+	return nil
+}
+
+func (d *Dialer) dial(conn net.Conn) (SendCloser, error) {
 	if d.SSL {
 		conn = tlsClient(conn, d.tlsConfig())
 	}
@@ -93,10 +229,6 @@ func (d *Dialer) Dial() (SendCloser, error) {
 		return nil, err
 	}
 
-	if d.Timeout > 0 {
-		conn.SetDeadline(time.Now().Add(d.Timeout))
-	}
-
 	if d.LocalName != "" {
 		if err := c.Hello(d.LocalName); err != nil {
 			return nil, err
@@ -201,13 +333,18 @@ func addr(host string, port int) string {
 // DialAndSend opens a connection to the SMTP server, sends the given emails and
 // closes the connection.
 func (d *Dialer) DialAndSend(m ...*Message) error {
-	s, err := d.Dial()
+	return d.DialAndSendContext(context.Background(), m...)
+}
+
+// DialAndSendContext is like DialAndSend but aborts when ctx is done.
+func (d *Dialer) DialAndSendContext(ctx context.Context, m ...*Message) error {
+	s, err := d.DialContext(ctx)
 	if err != nil {
 		return err
 	}
 	defer s.Close()
 
-	return Send(s, m...)
+	return SendContext(ctx, s, m...)
 }
 
 type smtpSender struct {
@@ -229,18 +366,57 @@ func (c *smtpSender) retryError(err error) bool {
 }
 
 func (c *smtpSender) Send(from string, to []string, msg io.WriterTo) error {
-	if c.d.Timeout > 0 {
-		c.conn.SetDeadline(time.Now().Add(c.d.Timeout))
+	return c.SendContext(context.Background(), from, to, msg)
+}
+
+// SendContext is like Send but aborts the SMTP transaction, including the
+// streaming of the message, when ctx is done.
+func (c *smtpSender) SendContext(ctx context.Context, from string, to []string, msg io.WriterTo) error {
+	if deadline := c.d.deadline(ctx); !deadline.IsZero() {
+		c.conn.SetDeadline(deadline)
 	}
 
-	if err := c.Mail(from); err != nil {
-		if c.retryError(err) {
+	stop := watchContext(ctx, c.conn)
+	err := c.send(ctx, from, to, msg)
+	if cerr := stop(); cerr != nil {
+		return cerr
+	}
+	if err != nil {
+		if cerr := contextError(ctx); cerr != nil {
+			return cerr
+		}
+	}
+
+	return err
+}
+
+// send uses the PIPELINING, CHUNKING, SIZE, 8BITMIME, BINARYMIME and DSN
+// extensions when the server supports them, and falls back to a command at a
+// time and DATA otherwise.
+func (c *smtpSender) send(ctx context.Context, from string, to []string, msg io.WriterTo) error {
+	text, ext := c.extensions()
+
+	// body is msg rendered for the server, msg is kept for a retry.
+	body := msg
+	var params envelopeParams
+	var err error
+	if text != nil {
+		if body, params, err = c.prepare(ext, msg, to); err != nil {
+			return err
+		}
+		err = c.envelope(text, from, to, params, ext.pipelining)
+	} else {
+		err = c.Mail(from)
+	}
+
+	if err != nil {
+		if c.retryError(err) && ctx.Err() == nil {
 			// This is probably due to a timeout, so reconnect and try again.
-			sc, derr := c.d.Dial()
+			sc, derr := c.d.DialContext(ctx)
 			if derr == nil {
 				if s, ok := sc.(*smtpSender); ok {
 					*c = *s
-					return c.Send(from, to, msg)
+					return c.SendContext(ctx, from, to, msg)
 				}
 			}
 		}
@@ -248,18 +424,24 @@ func (c *smtpSender) Send(from string, to []string, msg io.WriterTo) error {
 		return err
 	}
 
-	for _, addr := range to {
-		if err := c.Rcpt(addr); err != nil {
-			return err
+	if text == nil {
+		for _, addr := range to {
+			if err := c.Rcpt(addr); err != nil {
+				return err
+			}
 		}
 	}
 
+	if ext.chunking {
+		return c.sendChunks(text, body, params.binary)
+	}
+
 	w, err := c.Data()
 	if err != nil {
 		return err
 	}
 
-	if _, err = msg.WriteTo(w); err != nil {
+	if _, err = body.WriteTo(w); err != nil {
 		w.Close()
 		return err
 	}
@@ -274,9 +456,7 @@ func (c *smtpSender) Close() error {
 // Stubbed out for tests.
 var (
 	tlsClient     = tls.Client
-	smtpNewClient = func(conn net.Conn, host string) (smtpClient, error) {
-		return smtp.NewClient(conn, host)
-	}
+	smtpNewClient = newSMTPClient
 )
 
 type smtpClient interface {
@@ -2,11 +2,15 @@ package mail
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
+	"errors"
 	"io"
 	"net"
 	"net/smtp"
+	"net/textproto"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 )
@@ -271,6 +275,136 @@ func TestDialerTimeoutNoRetry(t *testing.T) {
 	}
 }
 
+func TestDialerContextCanceled(t *testing.T) {
+	l := listenTest(t, "tcp", "127.0.0.1:0")
+	// The server never greets the client.
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.Copy(io.Discard, conn)
+	}()
+
+	d := testNetworkDialer(l)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if _, err := d.DialContext(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("DialContext() error, got %v, want %v", err, context.DeadlineExceeded)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Error("DialContext() should return when ctx is done")
+	}
+}
+
+func TestDialerContextData(t *testing.T) {
+	l := listenTest(t, "tcp", "127.0.0.1:0")
+	serveTest(l, func(c *textproto.Conn) {
+		for {
+			line, err := c.ReadLine()
+			if err != nil {
+				return
+			}
+			switch {
+			case strings.HasPrefix(line, "EHLO"):
+				c.PrintfLine("250 localhost")
+			case line == "DATA":
+				// Stall after the data is received.
+				c.PrintfLine("354 Go ahead")
+				for {
+					if _, err := c.ReadLine(); err != nil {
+						return
+					}
+				}
+			default:
+				c.PrintfLine("250 OK")
+			}
+		}
+	})
+
+	d := testNetworkDialer(l)
+	s, err := d.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if err := SendContext(ctx, s, getTestMessage()); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("SendContext() error, got %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestDialerContextDeadlineReset(t *testing.T) {
+	l := listenTest(t, "tcp", "127.0.0.1:0")
+	serveESMTP(l, nil, nil)
+
+	d := testNetworkDialer(l)
+	d.Timeout = 0
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	s, err := d.DialContext(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	<-ctx.Done()
+
+	if err := Send(s, getTestMessage()); err != nil {
+		t.Errorf("Send() after the dial context expired: %v", err)
+	}
+}
+
+// testNetworkDialer returns a Dialer to the server listening on l, restoring
+// the functions stubbed by the other tests.
+func testNetworkDialer(l net.Listener) *Dialer {
+	NetDialTimeout = net.DialTimeout
+	tlsClient = tls.Client
+	smtpNewClient = newSMTPClient
+
+	a := l.Addr().(*net.TCPAddr)
+	return &Dialer{
+		Host:           a.IP.String(),
+		Port:           a.Port,
+		StartTLSPolicy: NoStartTLS,
+		Timeout:        10 * time.Second,
+	}
+}
+
+func listenTest(t *testing.T, network, address string) net.Listener {
+	l, err := net.Listen(network, address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	return l
+}
+
+// serveTest greets the first client connecting to l and hands the connection
+// to handle.
+func serveTest(l net.Listener, handle func(c *textproto.Conn)) {
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		c := textproto.NewConn(conn)
+		c.PrintfLine("220 localhost ESMTP")
+		handle(c)
+	}()
+}
+
 type mockClient struct {
 	t        *testing.T
 	i        int
@@ -1,6 +1,7 @@
 package mail
 
 import (
+	"bytes"
 	"encoding/base64"
 	"errors"
 	"io"
@@ -13,7 +14,30 @@ import (
 
 // WriteTo implements io.WriterTo. It dumps the whole message into w.
 func (m *Message) WriteTo(w io.Writer) (int64, error) {
-	mw := &messageWriter{w: w}
+	return m.writeTo(w, false, false)
+}
+
+// writeTo is like WriteTo but, if eightBit is set, uses the 8bit transfer
+// encoding for the quoted-printable text parts that allow it and, if binary is
+// set, the binary one for the base64 files. They may only be used if the
+// server supports 8BITMIME and BINARYMIME respectively. A binary message has
+// CRLF line endings outside of its files, so that it can be sent unchanged.
+func (m *Message) writeTo(w io.Writer, eightBit, binary bool) (int64, error) {
+	if m.dkim != nil {
+		var buf bytes.Buffer
+		mw := &messageWriter{w: &buf, eightBit: eightBit, binary: binary}
+		mw.writeMessage(m)
+		if mw.err != nil {
+			return 0, mw.err
+		}
+		return m.dkim.Sign(w, buf.Bytes())
+	}
+
+	mw := &messageWriter{w: w, eightBit: eightBit, binary: binary}
+	if binary {
+		mw.crlf = &crlfWriter{w: w}
+		mw.w = mw.crlf
+	}
 	mw.writeMessage(m)
 	return mw.n, mw.err
 }
@@ -27,6 +51,46 @@ func (w *messageWriter) writeMessage(m *Message) {
 	}
 	w.writeHeaders(m.header)
 
+	if m.smime != nil {
+		w.writeEntity(m, m.smime.wrap)
+		return
+	}
+
+	if m.pgp != nil {
+		w.writeEntity(m, m.pgp.wrap)
+		return
+	}
+
+	w.writeContent(m)
+}
+
+// writeEntity renders the MIME entity of the message, transforms it with wrap,
+// e.g. to sign or encrypt it, and writes the result.
+func (w *messageWriter) writeEntity(m *Message, wrap func([]byte) ([]byte, error)) {
+	if w.err != nil {
+		return
+	}
+
+	var buf bytes.Buffer
+	mw := &messageWriter{w: &buf}
+	mw.writeContent(m)
+	if mw.err != nil {
+		w.err = mw.err
+		return
+	}
+
+	entity, err := wrap(toCRLF(buf.Bytes()))
+	if err != nil {
+		w.err = err
+		return
+	}
+
+	w.Write(entity)
+}
+
+// writeContent writes the MIME entity of the message, that is its content
+// headers and body.
+func (w *messageWriter) writeContent(m *Message) {
 	if m.hasMixedPart() {
 		w.openMultipart("mixed", m.boundary)
 	}
@@ -75,6 +139,10 @@ type messageWriter struct {
 	partWriter io.Writer
 	depth      uint8
 	err        error
+	eightBit   bool
+	binary     bool
+	// crlf converts the line endings of binary messages.
+	crlf *crlfWriter
 }
 
 func (w *messageWriter) openMultipart(mimeType, boundary string) {
@@ -108,11 +176,29 @@ func (w *messageWriter) closeMultipart() {
 }
 
 func (w *messageWriter) writePart(p *part, charset string) {
+	copier, enc := p.copier, p.encoding
+
+	if w.eightBit && enc == QuotedPrintable {
+		var buf bytes.Buffer
+		if w.err = p.copier(&buf); w.err != nil {
+			return
+		}
+
+		body := buf.Bytes()
+		if crlf := toCRLF(body); fits8bit(crlf) {
+			body, enc = crlf, Unencoded
+		}
+		copier = func(out io.Writer) error {
+			_, err := out.Write(body)
+			return err
+		}
+	}
+
 	w.writeHeaders(map[string][]string{
 		"Content-Type":              {p.contentType + "; charset=" + charset},
-		"Content-Transfer-Encoding": {string(p.encoding)},
+		"Content-Transfer-Encoding": {string(enc)},
 	})
-	w.writeBody(p.copier, p.encoding)
+	w.writeBody(copier, enc)
 }
 
 func (w *messageWriter) addFiles(files []*file, isAttachment bool) {
@@ -144,6 +230,19 @@ func (w *messageWriter) addFiles(files []*file, isAttachment bool) {
 				f.setHeader("Content-ID", "<"+f.Name+">")
 			}
 		}
+		if w.binary && f.Header["Content-Transfer-Encoding"][0] == string(Base64) {
+			h := make(header, len(f.Header))
+			for k, v := range f.Header {
+				h[k] = v
+			}
+			h["Content-Transfer-Encoding"] = []string{string(binaryEncoding)}
+			w.writeHeaders(h)
+			w.crlf.raw = true
+			w.writeBody(f.CopyFunc, binaryEncoding)
+			w.crlf.raw = false
+			continue
+		}
+
 		w.writeHeaders(f.Header)
 		w.writeBody(f.CopyFunc, Base64)
 	}
@@ -270,7 +369,7 @@ func (w *messageWriter) writeBody(f func(io.Writer) error, enc Encoding) {
 		wc := base64.NewEncoder(base64.StdEncoding, newBase64LineWriter(subWriter))
 		w.err = f(wc)
 		wc.Close()
-	} else if enc == Unencoded {
+	} else if enc == Unencoded || enc == binaryEncoding {
 		w.err = f(subWriter)
 	} else {
 		wc := newQPWriter(subWriter)
@@ -279,6 +378,37 @@ func (w *messageWriter) writeBody(f func(io.Writer) error, enc Encoding) {
 	}
 }
 
+// crlfWriter converts bare LF line endings to CRLF, except while raw is set.
+type crlfWriter struct {
+	w   io.Writer
+	cr  bool
+	raw bool
+}
+
+func (w *crlfWriter) Write(p []byte) (int, error) {
+	if w.raw {
+		if len(p) > 0 {
+			w.cr = p[len(p)-1] == '\r'
+		}
+		return w.w.Write(p)
+	}
+
+	buf := make([]byte, 0, len(p)+bytes.Count(p, []byte("\n")))
+	for _, b := range p {
+		if b == '\n' && !w.cr {
+			buf = append(buf, '\r')
+		}
+		buf = append(buf, b)
+		w.cr = b == '\r'
+	}
+
+	if _, err := w.w.Write(buf); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
 // As required by RFC 2045, 6.7. (page 21) for quoted-printable, and
 // RFC 2045, 6.8. (page 25) for base64.
 const maxLineLen = 76
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/pkg/errors"
+)
+
+const (
+	cacheNegativeTTL = 300
+	cacheTTL         = 3600
+)
+
+// Attributes kept in cache, enough to resolve the address and check the account state
+var cacheAttributes = []string{"mail", "userAccountControl", "accountExpires", "pwdAccountLockedTime"}
+
+type CacheConfig struct {
+	NegativeTTL int    `json:"negative_ttl"`
+	Path        string `json:"path"`
+	TTL         int    `json:"ttl"`
+}
+
+type CacheEntry struct {
+	Attributes map[string][]string `json:"attributes,omitempty"`
+	DN         string              `json:"dn,omitempty"`
+	Miss       bool                `json:"miss,omitempty"`
+	Time       time.Time           `json:"time"`
+}
+
+type Cache struct {
+	entries     map[string]CacheEntry
+	negativeTTL time.Duration
+	path        string
+	scope       string
+	ttl         time.Duration
+}
+
+// cacheScope identifies the directory of config, so that configs sharing a cache
+// file do not see each other's lookups.
+func cacheScope(config *Config) string {
+	return fmt.Sprintf("%s:%d/%s", config.Host, config.Port, config.Base)
+}
+
+func openCache(config *CacheConfig, scope string) (*Cache, error) {
+	c := &Cache{
+		entries:     make(map[string]CacheEntry),
+		negativeTTL: cacheNegativeTTL * time.Second,
+		path:        config.Path,
+		scope:       scope,
+		ttl:         cacheTTL * time.Second,
+	}
+
+	if config.TTL > 0 {
+		c.ttl = time.Duration(config.TTL) * time.Second
+	}
+
+	if config.NegativeTTL > 0 {
+		c.negativeTTL = time.Duration(config.NegativeTTL) * time.Second
+	}
+
+	buf, err := os.ReadFile(c.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return c, nil
+		}
+		return nil, errors.Wrap(err, "read failed")
+	}
+
+	if err := json.Unmarshal(buf, &c.entries); err != nil {
+		return nil, errors.Wrap(err, "unmarshal failed")
+	}
+
+	return c, nil
+}
+
+func purgeCache(config *CacheConfig) error {
+	if config.Path == "" {
+		return errors.New("path null")
+	}
+
+	if err := os.Remove(config.Path); err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "remove failed")
+	}
+
+	return nil
+}
+
+func (c *Cache) key(filter, data string) string {
+	return c.scope + " " + filter + "=" + data
+}
+
+// Get returns the cached entry if it is still fresh. With stale set, expired entries
+// are returned as well so lookups keep working while the directory is unreachable.
+func (c *Cache) Get(filter, data string, now time.Time, stale bool) (CacheEntry, bool) {
+	entry, isPresent := c.entries[c.key(filter, data)]
+	if !isPresent {
+		return entry, false
+	}
+
+	if stale {
+		return entry, !entry.Miss
+	}
+
+	ttl := c.ttl
+	if entry.Miss {
+		ttl = c.negativeTTL
+	}
+
+	return entry, now.Sub(entry.Time) < ttl
+}
+
+func (c *Cache) Set(filter, data string, entry *ldap.Entry, now time.Time) {
+	if entry == nil {
+		c.entries[c.key(filter, data)] = CacheEntry{Miss: true, Time: now}
+		return
+	}
+
+	attributes := make(map[string][]string)
+
+	for _, item := range cacheAttributes {
+		if val := entry.GetAttributeValues(item); len(val) != 0 {
+			attributes[item] = val
+		}
+	}
+
+	c.entries[c.key(filter, data)] = CacheEntry{Attributes: attributes, DN: entry.DN, Time: now}
+}
+
+func (c *Cache) Save() error {
+	buf, err := json.Marshal(c.entries)
+	if err != nil {
+		return errors.Wrap(err, "marshal failed")
+	}
+
+	if err := os.MkdirAll(filepath.Dir(c.path), 0700); err != nil {
+		return errors.Wrap(err, "mkdir failed")
+	}
+
+	fi, err := os.CreateTemp(filepath.Dir(c.path), filepath.Base(c.path)+".*")
+	if err != nil {
+		return errors.Wrap(err, "create failed")
+	}
+
+	defer func() { _ = os.Remove(fi.Name()) }()
+
+	if _, err := fi.Write(buf); err != nil {
+		_ = fi.Close()
+		return errors.Wrap(err, "write failed")
+	}
+
+	if err := fi.Close(); err != nil {
+		return errors.Wrap(err, "close failed")
+	}
+
+	if err := os.Rename(fi.Name(), c.path); err != nil {
+		return errors.Wrap(err, "rename failed")
+	}
+
+	return nil
+}
+
+func (e *CacheEntry) Entry() *ldap.Entry {
+	return ldap.NewEntry(e.DN, e.Attributes)
+}
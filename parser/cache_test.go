@@ -0,0 +1,145 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+func TestOpenCache(t *testing.T) {
+	config := CacheConfig{Path: filepath.Join(t.TempDir(), "cache.json")}
+
+	c, err := openCache(&config, "ldap://localhost:389/DC=intra")
+	if err != nil {
+		t.Error("FAIL")
+	}
+
+	if c.ttl != cacheTTL*time.Second || c.negativeTTL != cacheNegativeTTL*time.Second {
+		t.Error("FAIL")
+	}
+
+	if err := os.WriteFile(config.Path, []byte("invalid"), 0600); err != nil {
+		t.Error("FAIL")
+	}
+
+	if _, err := openCache(&config, "ldap://localhost:389/DC=intra"); err == nil {
+		t.Error("FAIL")
+	}
+}
+
+func TestCache(t *testing.T) {
+	config := CacheConfig{
+		NegativeTTL: 10,
+		Path:        filepath.Join(t.TempDir(), "parser", "cache.json"),
+		TTL:         60,
+	}
+
+	c, err := openCache(&config, "ldap://localhost:389/DC=intra")
+	if err != nil {
+		t.Error("FAIL")
+	}
+
+	now := time.Now()
+
+	entry := ldap.NewEntry("CN=alen", map[string][]string{
+		"mail":               {"alen@example.com"},
+		"userAccountControl": {"512"},
+		"description":        {"ignored"},
+	})
+
+	c.Set("sAMAccountName", "alen", entry, now)
+	c.Set("mail", "bob", nil, now)
+
+	if err := c.Save(); err != nil {
+		t.Error("FAIL")
+	}
+
+	c, err = openCache(&config, "ldap://localhost:389/DC=intra")
+	if err != nil {
+		t.Error("FAIL")
+	}
+
+	e, ok := c.Get("sAMAccountName", "alen", now.Add(30*time.Second), false)
+	if !ok || e.Miss || e.Entry().GetAttributeValue("mail") != "alen@example.com" {
+		t.Error("FAIL")
+	}
+
+	if e.Entry().GetAttributeValue("description") != "" {
+		t.Error("FAIL")
+	}
+
+	if _, ok := c.Get("sAMAccountName", "alen", now.Add(90*time.Second), false); ok {
+		t.Error("FAIL")
+	}
+
+	if _, ok := c.Get("sAMAccountName", "alen", now.Add(90*time.Second), true); !ok {
+		t.Error("FAIL")
+	}
+
+	if e, ok := c.Get("mail", "bob", now.Add(5*time.Second), false); !ok || !e.Miss {
+		t.Error("FAIL")
+	}
+
+	if _, ok := c.Get("mail", "bob", now.Add(30*time.Second), false); ok {
+		t.Error("FAIL")
+	}
+
+	if _, ok := c.Get("mail", "bob", now, true); ok {
+		t.Error("FAIL")
+	}
+
+	if _, ok := c.Get("mail", "catherine", now, false); ok {
+		t.Error("FAIL")
+	}
+
+	c, err = openCache(&config, "ldap://localhost:389/DC=other")
+	if err != nil {
+		t.Error("FAIL")
+	}
+
+	if _, ok := c.Get("sAMAccountName", "alen", now, true); ok {
+		t.Error("FAIL")
+	}
+}
+
+func TestCacheScope(t *testing.T) {
+	config := Config{Base: "DC=intra", Host: "ldap://localhost", Port: 389}
+
+	if cacheScope(&config) != "ldap://localhost:389/DC=intra" {
+		t.Error("FAIL")
+	}
+
+	other := config
+	other.Base = "DC=other"
+
+	if cacheScope(&other) == cacheScope(&config) {
+		t.Error("FAIL")
+	}
+}
+
+func TestPurgeCache(t *testing.T) {
+	if err := purgeCache(&CacheConfig{}); err == nil {
+		t.Error("FAIL")
+	}
+
+	config := CacheConfig{Path: filepath.Join(t.TempDir(), "cache.json")}
+
+	if err := purgeCache(&config); err != nil {
+		t.Error("FAIL")
+	}
+
+	if err := os.WriteFile(config.Path, []byte("{}"), 0600); err != nil {
+		t.Error("FAIL")
+	}
+
+	if err := purgeCache(&config); err != nil {
+		t.Error("FAIL")
+	}
+
+	if _, err := os.Stat(config.Path); !os.IsNotExist(err) {
+		t.Error("FAIL")
+	}
+}
@@ -7,11 +7,15 @@ import (
 	"io"
 	"log"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/go-ldap/ldap/v3"
 	"github.com/pkg/errors"
 	"gopkg.in/alecthomas/kingpin.v2"
+
+	"github.com/craftslab/gomail/policy"
 )
 
 const (
@@ -20,24 +24,76 @@ const (
 )
 
 type Config struct {
-	Base string `json:"base"`
-	Host string `json:"host"`
-	Pass string `json:"pass"`
-	Port int    `json:"port"`
-	Sep  string `json:"sep"`
-	User string `json:"user"`
+	Base   string        `json:"base"`
+	Cache  CacheConfig   `json:"cache"`
+	Filter []policy.Rule `json:"filter"`
+	Host   string        `json:"host"`
+	Pass   string        `json:"pass"`
+	Port   int           `json:"port"`
+	Sep    string        `json:"sep"`
+	User   string        `json:"user"`
+}
+
+type Fetch struct {
+	Errors     map[string]error
+	Match      []Match
+	Skipped    []string
+	Unresolved []string
+}
+
+type Match struct {
+	Name      string `json:"name"`
+	Address   string `json:"address"`
+	Attribute string `json:"attribute"`
+}
+
+type Filtered struct {
+	Address string `json:"address"`
+	Reason  string `json:"reason"`
+}
+
+type Output struct {
+	To         []Match    `json:"to"`
+	Cc         []Match    `json:"cc"`
+	Unresolved []string   `json:"unresolved"`
+	Skipped    []string   `json:"skipped"`
+	Filtered   []Filtered `json:"filtered"`
 }
 
 var (
 	app = kingpin.New("parser", "Recipient parser").Author(author).Version(version)
 
 	config     = app.Flag("config", "Config file, format: .json").Short('c').String()
-	filter     = app.Flag("filter", "Filter list, format: @example1.com,@example2.com").Short('f').String()
-	recipients = app.Flag("recipients", "Recipients list, format: alen,cc:bob@example.com").Short('r').Required().String()
+	filter     = app.Flag("filter", "Filter list, format: @example1.com,.example2.com,!*@example3.com,re:ci-.*").Short('f').String()
+	output     = app.Flag("output", "Output format, format: text (default) or json").Short('o').Default("text").Enum("text", "json")
+	noCache    = app.Flag("no-cache", "Bypass the lookup cache").Bool()
+	recipients = app.Flag("recipients", "Recipients list, format: alen,cc:bob@example.com").Short('r').String()
+	skip       = app.Flag("skip-disabled", "Skip disabled, expired or locked directory accounts").Short('s').Bool()
+	strict     = app.Flag("strict", "Exit with code 2 if any recipient is unresolved").Bool()
+
+	_          = app.Command("resolve", "Resolve recipients (default)").Default()
+	cacheCmd   = app.Command("cache", "Lookup cache")
+	cachePurge = cacheCmd.Command("purge", "Remove all cached lookups")
+)
+
+const (
+	exitUnresolved = 2
+)
+
+var (
+	errSearchNull = errors.New("search null")
+)
+
+const (
+	// https://learn.microsoft.com/en-us/troubleshoot/windows-server/active-directory/useraccountcontrol-manipulate-account-properties
+	accountDisable = 0x0002
+	// Number of 100-nanosecond intervals between 1601-01-01 and 1970-01-01
+	fileTimeOffset = 116444736000000000
+	fileTimeNever  = 0x7FFFFFFFFFFFFFFF
 )
 
 func main() {
-	kingpin.MustParse(app.Parse(os.Args[1:]))
+	command := kingpin.MustParse(app.Parse(os.Args[1:]))
 
 	config, err := parseConfig(*config)
 	if err != nil {
@@ -45,6 +101,14 @@ func main() {
 		os.Exit(1)
 	}
 
+	if command == cachePurge.FullCommand() {
+		if err := purgeCache(&config.Cache); err != nil {
+			log.Println("Failed to purge cache")
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
 	filter, err := parseFilter(&config, *filter)
 	if err != nil {
 		log.Println("Invalid filter")
@@ -57,19 +121,53 @@ func main() {
 		os.Exit(1)
 	}
 
-	cc, err = fetchAddress(&config, cc)
+	var cache *Cache
+
+	if config.Cache.Path != "" && !*noCache {
+		if cache, err = openCache(&config.Cache, cacheScope(&config)); err != nil {
+			log.Println("Invalid cache, ignored")
+		}
+	}
+
+	ccFetch, err := fetchAddress(&config, cc, *skip, cache)
 	if err != nil {
 		log.Println("Failed to fetch cc address")
 		os.Exit(1)
 	}
 
-	to, err = fetchAddress(&config, to)
+	toFetch, err := fetchAddress(&config, to, *skip, cache)
 	if err != nil {
 		log.Println("Failed to fetch to address")
 		os.Exit(1)
 	}
 
-	printAddress(cc, to, filter)
+	if cache != nil {
+		if err := cache.Save(); err != nil {
+			log.Println("Failed to save cache")
+		}
+	}
+
+	unresolved := printUnresolved(&ccFetch, &toFetch)
+
+	if *output == "json" {
+		if err := printJSON(os.Stdout, &ccFetch, &toFetch, filter); err != nil {
+			log.Println("Failed to print json")
+			os.Exit(1)
+		}
+		if *strict && len(unresolved) != 0 {
+			os.Exit(exitUnresolved)
+		}
+		os.Exit(0)
+	}
+
+	if *strict && len(unresolved) != 0 {
+		log.Println("Unresolved recipients:", strings.Join(unresolved, config.Sep))
+		os.Exit(exitUnresolved)
+	}
+
+	printSkipped(append(toFetch.Skipped, ccFetch.Skipped...))
+
+	printAddress(matchAddress(ccFetch.Match), matchAddress(toFetch.Match), filter)
 
 	os.Exit(0)
 }
@@ -92,21 +190,19 @@ func parseConfig(name string) (Config, error) {
 	return config, nil
 }
 
-func parseFilter(config *Config, data string) ([]string, error) {
-	var filter []string
+func parseFilter(config *Config, data string) (*policy.Policy, error) {
+	var rules []policy.Rule
 
-	if data == "" {
-		return filter, nil
+	if data != "" {
+		rules = policy.Parse(data, config.Sep)
 	}
 
-	buf := strings.Split(data, config.Sep)
-	for _, item := range buf {
-		if item != "" {
-			filter = append(filter, item)
-		}
-	}
+	rules = append(rules, config.Filter...)
 
-	filter = removeDuplicates(filter)
+	filter, err := policy.New(rules)
+	if err != nil {
+		return nil, errors.Wrap(err, "policy failed")
+	}
 
 	return filter, nil
 }
@@ -133,7 +229,7 @@ func parseRecipients(config *Config, data string) (cc, to []string) {
 }
 
 // nolint:gosec
-func fetchAddress(config *Config, data []string) ([]string, error) {
+func fetchAddress(config *Config, data []string, skip bool, cache *Cache) (Fetch, error) {
 	fetch := func(data string) string {
 		buf := strings.Split(data, "@")
 		if len(buf) == 0 {
@@ -142,59 +238,116 @@ func fetchAddress(config *Config, data []string) ([]string, error) {
 		return buf[0]
 	}
 
-	query := func(filter, data string) (string, error) {
+	query := func(filter, data string) (*ldap.Entry, error) {
 		l, err := ldap.DialURL(fmt.Sprintf("%s:%d", config.Host, config.Port))
 		if err != nil {
-			return "", errors.Wrap(err, "dial failed")
+			return nil, errors.Wrap(err, "dial failed")
 		}
 		defer l.Close()
 		if err = l.StartTLS(&tls.Config{InsecureSkipVerify: true}); err != nil {
-			return "", errors.Wrap(err, "start failed")
+			return nil, errors.Wrap(err, "start failed")
 		}
 		if err = l.Bind(config.User, config.Pass); err != nil {
-			return "", errors.Wrap(err, "bind failed")
+			return nil, errors.Wrap(err, "bind failed")
 		}
 		searchRequest := ldap.NewSearchRequest(
 			config.Base,
 			ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
 			fmt.Sprintf("(%s=%s)", filter, data),
-			[]string{"*"},
+			[]string{"*", "pwdAccountLockedTime"},
 			nil,
 		)
 		result, err := l.Search(searchRequest)
 		if err != nil {
-			return "", errors.Wrap(err, "search failed")
+			return nil, errors.Wrap(err, "search failed")
 		}
 		if len(result.Entries) < 1 {
-			return "", errors.New("search null")
+			return nil, errSearchNull
 		}
-		return result.Entries[0].GetAttributeValue("mail"), nil
+		return result.Entries[0], nil
 	}
 
-	var buf []string
+	lookup := func(filter, data string) (*ldap.Entry, error) {
+		if cache == nil {
+			return query(filter, data)
+		}
+		now := time.Now()
+		if e, ok := cache.Get(filter, data, now, false); ok {
+			if e.Miss {
+				return nil, errSearchNull
+			}
+			return e.Entry(), nil
+		}
+		entry, err := query(filter, data)
+		if err == nil || errors.Cause(err) == errSearchNull {
+			cache.Set(filter, data, entry, now)
+			return entry, err
+		}
+		if e, ok := cache.Get(filter, data, now, true); ok {
+			return e.Entry(), nil
+		}
+		return nil, err
+	}
+
+	buf := Fetch{Errors: make(map[string]error)}
 
 	for _, item := range data {
-		address, err := query("mail", item)
+		attribute := "mail"
+		entry, err := lookup(attribute, item)
 		if err != nil {
-			if addr, err := query("sAMAccountName", fetch(item)); err == nil {
-				address = addr
+			if entry, err = lookup("sAMAccountName", fetch(item)); err == nil {
+				attribute = "sAMAccountName"
 			}
 		}
-		if address != "" {
-			buf = append(buf, address)
+		if err != nil {
+			buf.Errors[item] = err
+			buf.Unresolved = append(buf.Unresolved, item)
+			continue
+		}
+		if skip && isDisabled(entry, time.Now()) {
+			buf.Skipped = append(buf.Skipped, item)
+			continue
+		}
+		if address := entry.GetAttributeValue("mail"); address != "" {
+			buf.Match = append(buf.Match, Match{Name: item, Address: address, Attribute: attribute})
+		} else {
+			buf.Errors[item] = errors.New("mail null")
+			buf.Unresolved = append(buf.Unresolved, item)
 		}
 	}
 
 	return buf, nil
 }
 
-func printAddress(cc, to, filter []string) {
+// isDisabled reports whether the directory account is disabled, expired or locked
+// according to Active Directory (userAccountControl, accountExpires) or
+// OpenLDAP ppolicy (pwdAccountLockedTime) attributes.
+func isDisabled(entry *ldap.Entry, now time.Time) bool {
+	if buf := entry.GetAttributeValue("userAccountControl"); buf != "" {
+		if flags, err := strconv.ParseInt(buf, 10, 64); err == nil && flags&accountDisable != 0 {
+			return true
+		}
+	}
+
+	if buf := entry.GetAttributeValue("accountExpires"); buf != "" {
+		if expires, err := strconv.ParseInt(buf, 10, 64); err == nil && expires > 0 && expires != fileTimeNever {
+			ticks := expires - fileTimeOffset
+			if time.Unix(ticks/1e7, ticks%1e7*100).Before(now) {
+				return true
+			}
+		}
+	}
+
+	return entry.GetAttributeValue("pwdAccountLockedTime") != ""
+}
+
+func printAddress(cc, to []string, filter *policy.Policy) {
 	cc = removeDuplicates(cc)
 	to = removeDuplicates(to)
 	cc = collectDifference(cc, to)
 
 	for _, item := range to {
-		if err := filterAddress(item, filter); err == nil {
+		if err := filter.Check(item); err == nil {
 			fmt.Printf("%s,", item)
 		}
 	}
@@ -204,16 +357,94 @@ func printAddress(cc, to, filter []string) {
 	}
 
 	for index := 0; index < len(cc)-1; index++ {
-		if err := filterAddress(cc[index], filter); err == nil {
+		if err := filter.Check(cc[index]); err == nil {
 			fmt.Printf("cc:%s,", cc[index])
 		}
 	}
 
-	if err := filterAddress(cc[len(cc)-1], filter); err == nil {
+	if err := filter.Check(cc[len(cc)-1]); err == nil {
 		fmt.Printf("cc:%s\n", cc[len(cc)-1])
 	}
 }
 
+func printJSON(w io.Writer, cc, to *Fetch, filter *policy.Policy) error {
+	out := Output{
+		To:         []Match{},
+		Cc:         []Match{},
+		Unresolved: removeDuplicates(append(append([]string{}, to.Unresolved...), cc.Unresolved...)),
+		Skipped:    removeDuplicates(append(append([]string{}, to.Skipped...), cc.Skipped...)),
+		Filtered:   []Filtered{},
+	}
+
+	toMatch := removeDuplicateMatches(to.Match)
+	toAddress := matchAddress(toMatch)
+
+	for _, item := range toMatch {
+		if err := filter.Check(item.Address); err != nil {
+			out.Filtered = append(out.Filtered, Filtered{Address: item.Address, Reason: err.Error()})
+		} else {
+			out.To = append(out.To, item)
+		}
+	}
+
+	for _, item := range removeDuplicateMatches(cc.Match) {
+		if contains(toAddress, item.Address) {
+			continue
+		}
+		if err := filter.Check(item.Address); err != nil {
+			out.Filtered = append(out.Filtered, Filtered{Address: item.Address, Reason: err.Error()})
+		} else {
+			out.Cc = append(out.Cc, item)
+		}
+	}
+
+	if out.Unresolved == nil {
+		out.Unresolved = []string{}
+	}
+
+	if out.Skipped == nil {
+		out.Skipped = []string{}
+	}
+
+	buf, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "marshal failed")
+	}
+
+	if _, err := fmt.Fprintln(w, string(buf)); err != nil {
+		return errors.Wrap(err, "write failed")
+	}
+
+	return nil
+}
+
+// printUnresolved warns about each name that could not be resolved and returns them.
+func printUnresolved(cc, to *Fetch) []string {
+	var buf []string
+
+	for _, item := range []*Fetch{to, cc} {
+		for _, name := range item.Unresolved {
+			if contains(buf, name) {
+				continue
+			}
+			buf = append(buf, name)
+			if err, isPresent := item.Errors[name]; isPresent {
+				log.Printf("Unresolved recipient: %s (%v)\n", name, err)
+			} else {
+				log.Printf("Unresolved recipient: %s\n", name)
+			}
+		}
+	}
+
+	return buf
+}
+
+func printSkipped(skipped []string) {
+	for _, item := range removeDuplicates(skipped) {
+		log.Println("Skipped disabled account:", item)
+	}
+}
+
 func removeDuplicates(data []string) []string {
 	var buf []string
 	key := make(map[string]bool)
@@ -228,6 +459,40 @@ func removeDuplicates(data []string) []string {
 	return buf
 }
 
+func removeDuplicateMatches(data []Match) []Match {
+	var buf []Match
+	key := make(map[string]bool)
+
+	for _, item := range data {
+		if _, isPresent := key[item.Address]; !isPresent {
+			key[item.Address] = true
+			buf = append(buf, item)
+		}
+	}
+
+	return buf
+}
+
+func matchAddress(data []Match) []string {
+	var buf []string
+
+	for _, item := range data {
+		buf = append(buf, item.Address)
+	}
+
+	return buf
+}
+
+func contains(data []string, item string) bool {
+	for _, val := range data {
+		if val == item {
+			return true
+		}
+	}
+
+	return false
+}
+
 func collectDifference(data, other []string) []string {
 	var buf []string
 	key := make(map[string]bool)
@@ -246,18 +511,3 @@ func collectDifference(data, other []string) []string {
 
 	return buf
 }
-
-func filterAddress(data string, filter []string) error {
-	err := errors.New("filter failed")
-
-	for _, item := range filter {
-		if endsWith := strings.HasSuffix(data, item); endsWith {
-			if data != item {
-				err = nil
-			}
-			break
-		}
-	}
-
-	return err
-}
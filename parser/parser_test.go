@@ -1,7 +1,17 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/pkg/errors"
+
+	"github.com/craftslab/gomail/policy"
 )
 
 func TestParseConfig(t *testing.T) {
@@ -21,6 +31,27 @@ func TestParseFilter(t *testing.T) {
 	if _, err := parseFilter(&config, filter); err != nil {
 		t.Error("FAIL")
 	}
+
+	config.Filter = []policy.Rule{{Action: policy.Deny, Pattern: "*@example.org", Reason: "external"}}
+
+	p, err := parseFilter(&config, "!bob@example.com,example.org")
+	if err != nil {
+		t.Error("FAIL")
+	}
+
+	if err := p.Check("bob@example.com"); err == nil {
+		t.Error("FAIL")
+	}
+
+	if err := p.Check("alen@example.org"); err != nil {
+		t.Error("FAIL")
+	}
+
+	config.Filter = []policy.Rule{{Action: "block", Pattern: "*@example.org"}}
+
+	if _, err := parseFilter(&config, ""); err == nil {
+		t.Error("FAIL")
+	}
 }
 
 func TestParseRecipients(t *testing.T) {
@@ -38,7 +69,7 @@ func TestParseRecipients(t *testing.T) {
 }
 
 func TestPrintAddress(t *testing.T) {
-	filter := []string{"@example.com"}
+	filter, _ := policy.New(policy.Parse("@example.com", ","))
 
 	cc := []string{"alen@example.com"}
 	to := []string{"bob@example.com"}
@@ -51,6 +82,81 @@ func TestPrintAddress(t *testing.T) {
 	printAddress(cc, to, filter)
 }
 
+func TestPrintJSON(t *testing.T) {
+	filter, _ := policy.New(policy.Parse("@example.com", ","))
+
+	cc := Fetch{
+		Match: []Match{
+			{Name: "alen", Address: "alen@example.com", Attribute: "sAMAccountName"},
+			{Name: "bob", Address: "bob@example.com", Attribute: "mail"},
+		},
+		Unresolved: []string{"catherine"},
+	}
+
+	to := Fetch{
+		Match: []Match{
+			{Name: "bob", Address: "bob@example.com", Attribute: "mail"},
+			{Name: "david", Address: "david@example.org", Attribute: "mail"},
+		},
+		Skipped: []string{"eve"},
+	}
+
+	var buf bytes.Buffer
+	if err := printJSON(&buf, &cc, &to, filter); err != nil {
+		t.Fatal("FAIL")
+	}
+
+	var out Output
+	if err := json.Unmarshal(buf.Bytes(), &out); err != nil {
+		t.Fatal("FAIL")
+	}
+
+	if !reflect.DeepEqual(out.To, []Match{{Name: "bob", Address: "bob@example.com", Attribute: "mail"}}) {
+		t.Error("FAIL")
+	}
+
+	if !reflect.DeepEqual(out.Cc, []Match{{Name: "alen", Address: "alen@example.com", Attribute: "sAMAccountName"}}) {
+		t.Error("FAIL")
+	}
+
+	if !reflect.DeepEqual(out.Unresolved, []string{"catherine"}) || !reflect.DeepEqual(out.Skipped, []string{"eve"}) {
+		t.Error("FAIL")
+	}
+
+	if len(out.Filtered) != 1 || out.Filtered[0].Address != "david@example.org" || out.Filtered[0].Reason == "" {
+		t.Error("FAIL")
+	}
+
+	buf.Reset()
+	if err := printJSON(&buf, &Fetch{}, &Fetch{}, filter); err != nil {
+		t.Fatal("FAIL")
+	}
+
+	if !strings.Contains(buf.String(), `"to": []`) || !strings.Contains(buf.String(), `"unresolved": []`) ||
+		!strings.Contains(buf.String(), `"skipped": []`) || !strings.Contains(buf.String(), `"filtered": []`) {
+		t.Error("FAIL")
+	}
+}
+
+func TestPrintUnresolved(t *testing.T) {
+	cc := Fetch{
+		Errors:     map[string]error{"alen": errors.New("search null")},
+		Unresolved: []string{"alen", "bob"},
+	}
+
+	to := Fetch{
+		Unresolved: []string{"bob", "catherine"},
+	}
+
+	if buf := printUnresolved(&cc, &to); len(buf) != 3 || buf[0] != "bob" {
+		t.Error("FAIL")
+	}
+
+	if buf := printUnresolved(&Fetch{}, &Fetch{}); len(buf) != 0 {
+		t.Error("FAIL")
+	}
+}
+
 func TestRemoveDuplicates(t *testing.T) {
 	buf := []string{"alen@example.com", "bob@example.com", "alen@example.com"}
 	buf = removeDuplicates(buf)
@@ -83,16 +189,63 @@ func TestCollectDifference(t *testing.T) {
 	}
 }
 
-func TestFilterAddress(t *testing.T) {
-	filter := []string{"@example.com"}
+func TestIsDisabled(t *testing.T) {
+	now := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
 
-	address := "alen@example.com"
-	if err := filterAddress(address, filter); err != nil {
+	entry := ldap.NewEntry("CN=alen", map[string][]string{
+		"mail":               {"alen@example.com"},
+		"userAccountControl": {"512"},
+		"accountExpires":     {"9223372036854775807"},
+	})
+	if isDisabled(entry, now) {
 		t.Error("FAIL")
 	}
 
-	address = "@example.com"
-	if err := filterAddress(address, filter); err == nil {
+	entry = ldap.NewEntry("CN=alen", map[string][]string{
+		"userAccountControl": {"514"},
+	})
+	if !isDisabled(entry, now) {
+		t.Error("FAIL")
+	}
+
+	// 2023-01-01T00:00:00Z
+	entry = ldap.NewEntry("CN=alen", map[string][]string{
+		"accountExpires": {"133170048000000000"},
+	})
+	if !isDisabled(entry, now) {
+		t.Error("FAIL")
+	}
+
+	// 2551-08-31T05:20:00Z
+	entry = ldap.NewEntry("CN=alen", map[string][]string{
+		"accountExpires": {"300000000000000000"},
+	})
+	if isDisabled(entry, now) {
+		t.Error("FAIL")
+	}
+
+	entry = ldap.NewEntry("CN=alen", map[string][]string{
+		"accountExpires": {"0"},
+	})
+	if isDisabled(entry, now) {
+		t.Error("FAIL")
+	}
+
+	entry = ldap.NewEntry("uid=alen", map[string][]string{
+		"pwdAccountLockedTime": {"000001010000Z"},
+	})
+	if !isDisabled(entry, now) {
+		t.Error("FAIL")
+	}
+}
+
+func TestRemoveDuplicateMatches(t *testing.T) {
+	buf := []Match{
+		{Name: "alen", Address: "alen@example.com", Attribute: "mail"},
+		{Name: "alen@example.com", Address: "alen@example.com", Attribute: "sAMAccountName"},
+	}
+
+	if buf = removeDuplicateMatches(buf); len(buf) != 1 || buf[0].Attribute != "mail" {
 		t.Error("FAIL")
 	}
 }
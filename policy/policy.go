@@ -0,0 +1,174 @@
+package policy
+
+import (
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	Allow = "allow"
+	Deny  = "deny"
+)
+
+const (
+	denyPrefix  = "!"
+	regexPrefix = "re:"
+)
+
+// Rule matches recipient addresses by pattern.
+//
+// Pattern formats:
+//
+//	re:ci-.*@example\.com    regular expression matching the whole address
+//	*@*.example.com          glob against the whole address
+//	@example.com             exact domain
+//	.example.com             subdomains of example.com only
+//	example.com              example.com and all of its subdomains
+//	alen@example.com         exact address
+type Rule struct {
+	Action  string `json:"action"`
+	Pattern string `json:"pattern"`
+	Reason  string `json:"reason"`
+}
+
+type Policy struct {
+	allow bool
+	rules []rule
+}
+
+type rule struct {
+	Rule
+	match func(local, domain, address string) bool
+}
+
+// New compiles rules into a policy. Rules are evaluated in order and the first
+// match wins. An address matching no rule is allowed unless the policy has any
+// allow rule, so a policy without rules allows every address.
+func New(rules []Rule) (*Policy, error) {
+	p := &Policy{}
+
+	for _, item := range rules {
+		action := strings.ToLower(strings.TrimSpace(item.Action))
+		if action == "" {
+			action = Allow
+		}
+		if action != Allow && action != Deny {
+			return nil, errors.Errorf("action invalid: %s", item.Action)
+		}
+		item.Action = action
+		item.Pattern = strings.TrimSpace(item.Pattern)
+		if item.Pattern == "" {
+			return nil, errors.New("pattern null")
+		}
+		match, err := compile(item.Pattern)
+		if err != nil {
+			return nil, errors.Wrapf(err, "pattern invalid: %s", item.Pattern)
+		}
+		if action == Allow {
+			p.allow = true
+		}
+		p.rules = append(p.rules, rule{item, match})
+	}
+
+	return p, nil
+}
+
+// Parse parses a rule list in command line format, e.g. "@example.com,!*@example.org".
+// A leading "!" makes a deny rule.
+func Parse(data, sep string) []Rule {
+	var buf []Rule
+
+	for _, item := range strings.Split(data, sep) {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if strings.HasPrefix(item, denyPrefix) {
+			buf = append(buf, Rule{Action: Deny, Pattern: strings.TrimPrefix(item, denyPrefix)})
+		} else {
+			buf = append(buf, Rule{Action: Allow, Pattern: item})
+		}
+	}
+
+	return buf
+}
+
+// Check returns an error with the rejection reason if the address is not allowed.
+func (p *Policy) Check(address string) error {
+	address = strings.ToLower(strings.TrimSpace(address))
+
+	index := strings.LastIndex(address, "@")
+	if index <= 0 || index == len(address)-1 {
+		return errors.New("address invalid")
+	}
+
+	local, domain := address[:index], address[index+1:]
+
+	for _, item := range p.rules {
+		if !item.match(local, domain, address) {
+			continue
+		}
+		if item.Action == Allow {
+			return nil
+		}
+		if item.Reason != "" {
+			return errors.New(item.Reason)
+		}
+		return errors.Errorf("denied by %s", item.Pattern)
+	}
+
+	if p.allow {
+		return errors.New("not in allow list")
+	}
+
+	return nil
+}
+
+func compile(pattern string) (func(local, domain, address string) bool, error) {
+	if strings.HasPrefix(pattern, regexPrefix) {
+		re, err := regexp.Compile("(?i)^(?:" + strings.TrimPrefix(pattern, regexPrefix) + ")$")
+		if err != nil {
+			return nil, err
+		}
+		return func(_, _, address string) bool {
+			return re.MatchString(address)
+		}, nil
+	}
+
+	pattern = strings.ToLower(pattern)
+
+	if strings.ContainsAny(pattern, "*?[") {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, err
+		}
+		return func(_, _, address string) bool {
+			matched, _ := path.Match(pattern, address)
+			return matched
+		}, nil
+	}
+
+	if strings.HasPrefix(pattern, "@") {
+		return func(_, domain, _ string) bool {
+			return domain == pattern[1:]
+		}, nil
+	}
+
+	if strings.HasPrefix(pattern, ".") {
+		return func(_, domain, _ string) bool {
+			return strings.HasSuffix(domain, pattern)
+		}, nil
+	}
+
+	if strings.Contains(pattern, "@") {
+		return func(_, _, address string) bool {
+			return address == pattern
+		}, nil
+	}
+
+	return func(_, domain, _ string) bool {
+		return domain == pattern || strings.HasSuffix(domain, "."+pattern)
+	}, nil
+}
@@ -0,0 +1,117 @@
+package policy
+
+import (
+	"testing"
+)
+
+func TestNew(t *testing.T) {
+	if _, err := New(nil); err != nil {
+		t.Error("FAIL")
+	}
+
+	if _, err := New([]Rule{{Action: "block", Pattern: "@example.com"}}); err == nil {
+		t.Error("FAIL")
+	}
+
+	if _, err := New([]Rule{{Action: Allow, Pattern: ""}}); err == nil {
+		t.Error("FAIL")
+	}
+
+	if _, err := New([]Rule{{Action: Deny, Pattern: "re:("}}); err == nil {
+		t.Error("FAIL")
+	}
+
+	if _, err := New([]Rule{{Action: Deny, Pattern: "[*@example.com"}}); err == nil {
+		t.Error("FAIL")
+	}
+}
+
+func TestParse(t *testing.T) {
+	buf := Parse("@example.com,,!*@example.org, example.net ", ",")
+	if len(buf) != 3 {
+		t.Error("FAIL")
+	}
+
+	if buf[0].Action != Allow || buf[0].Pattern != "@example.com" {
+		t.Error("FAIL")
+	}
+
+	if buf[1].Action != Deny || buf[1].Pattern != "*@example.org" {
+		t.Error("FAIL")
+	}
+
+	if buf[2].Action != Allow || buf[2].Pattern != "example.net" {
+		t.Error("FAIL")
+	}
+}
+
+func TestCheck(t *testing.T) {
+	p, err := New(nil)
+	if err != nil {
+		t.Error("FAIL")
+	}
+
+	if err := p.Check("alen@example.com"); err != nil {
+		t.Error("FAIL")
+	}
+
+	if err := p.Check("@example.com"); err == nil {
+		t.Error("FAIL")
+	}
+
+	p, err = New([]Rule{
+		{Action: Deny, Pattern: "bob@example.com", Reason: "bob left"},
+		{Action: Deny, Pattern: "re:ci-[0-9]+@.*"},
+		{Action: Allow, Pattern: "@example.com"},
+		{Action: Allow, Pattern: ".example.org"},
+		{Action: Allow, Pattern: "example.net"},
+		{Action: Allow, Pattern: "*@partner-*.com"},
+		{Action: Allow, Pattern: `re:.*@trusted\.com`},
+	})
+	if err != nil {
+		t.Error("FAIL")
+	}
+
+	tests := map[string]string{
+		"alen@example.com":         "",
+		"Alen@EXAMPLE.com":         "",
+		"bob@example.com":          "bob left",
+		"ci-42@example.com":        "denied by re:ci-[0-9]+@.*",
+		"alen@mail.example.com":    "not in allow list",
+		"alen@example.org":         "not in allow list",
+		"alen@mail.example.org":    "",
+		"alen@example.net":         "",
+		"alen@mail.example.net":    "",
+		"alen@myexample.net":       "not in allow list",
+		"alen@partner-foo.com":     "",
+		"alen@trusted.com":         "",
+		"alen@trusted.com.evil.io": "not in allow list",
+		"ci-42x@example.com":       "",
+		"@example.com":             "address invalid",
+		"alen@":                    "address invalid",
+		"catherine@example.com.cn": "not in allow list",
+	}
+
+	for address, reason := range tests {
+		err := p.Check(address)
+		if reason == "" && err != nil {
+			t.Errorf("FAIL: %s: %v", address, err)
+		}
+		if reason != "" && (err == nil || err.Error() != reason) {
+			t.Errorf("FAIL: %s: %v", address, err)
+		}
+	}
+
+	p, err = New([]Rule{{Action: Deny, Pattern: "example.org"}})
+	if err != nil {
+		t.Error("FAIL")
+	}
+
+	if err := p.Check("alen@example.com"); err != nil {
+		t.Error("FAIL")
+	}
+
+	if err := p.Check("alen@example.org"); err == nil {
+		t.Error("FAIL")
+	}
+}
@@ -0,0 +1,411 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	bundleZip   = "zip"
+	bundleTarGz = "tar.gz"
+)
+
+// expandAttachment returns the files of an attachment item, which is a file, a
+// directory whose files are attached recursively, or a glob pattern such as
+// reports/*.pdf.
+func expandAttachment(item string) ([]string, error) {
+	if strings.ContainsAny(item, "*?[") {
+		matches, err := filepath.Glob(item)
+		if err != nil {
+			return nil, errors.Errorf("attachment pattern invalid: %s", item)
+		}
+		var names []string
+		for _, match := range matches {
+			if info, err := os.Lstat(match); err == nil && info.Mode().IsRegular() {
+				names = append(names, match)
+			}
+		}
+		if len(names) == 0 {
+			return nil, errors.Errorf("attachment pattern matched no files: %s", item)
+		}
+		return names, nil
+	}
+
+	if info, err := os.Stat(item); err == nil && info.IsDir() {
+		var names []string
+		err = filepath.WalkDir(item, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.Type().IsRegular() {
+				names = append(names, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, "walk failed")
+		}
+		if len(names) == 0 {
+			return nil, errors.Errorf("attachment directory has no files: %s", item)
+		}
+		sort.Strings(names)
+		return names, nil
+	}
+
+	name, err := checkFile(item)
+	if err != nil {
+		return nil, errors.Wrapf(err, "attachment invalid: %s", item)
+	}
+
+	return []string{name}, nil
+}
+
+func attachmentSize(names []string) (int64, error) {
+	var size int64
+
+	for _, item := range names {
+		info, err := os.Stat(item)
+		if err != nil {
+			return 0, errors.Wrap(err, "stat failed")
+		}
+		size += info.Size()
+	}
+
+	return size, nil
+}
+
+// checkAttachmentSize checks the total size of attachments against the limit of
+// config, if any.
+func checkAttachmentSize(config *Config, size int64) error {
+	if limit := config.Attachment.MaxSize; limit > 0 && size > limit {
+		return errors.Errorf("attachments too large: %s exceeds the limit of %s", formatSize(size), formatSize(limit))
+	}
+
+	return nil
+}
+
+func formatSize(size int64) string {
+	const unit = 1024
+
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+// writeBundle writes the files to an archive in the given format. The files
+// under the working directory keep their relative path, the others are stored
+// by base name.
+func writeBundle(w io.Writer, names []string, format string) error {
+	entries, err := bundleEntries(names)
+	if err != nil {
+		return err
+	}
+
+	switch format {
+	case bundleZip:
+		return writeZip(w, names, entries)
+	case bundleTarGz:
+		return writeTarGz(w, names, entries)
+	}
+
+	return errors.Errorf("attachment bundle invalid: %s (want zip or tar.gz)", format)
+}
+
+func bundleEntries(names []string) ([]string, error) {
+	wd, err := os.Getwd()
+	if err != nil {
+		return nil, errors.Wrap(err, "getwd failed")
+	}
+
+	entries := make([]string, len(names))
+	found := map[string]string{}
+
+	for i, item := range names {
+		entry := filepath.Base(item)
+		if abs, err := filepath.Abs(item); err == nil {
+			if rel, err := filepath.Rel(wd, abs); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+				entry = rel
+			}
+		}
+		entry = filepath.ToSlash(entry)
+		if prev, ok := found[entry]; ok {
+			return nil, errors.Errorf("attachment bundle has duplicate name %s: %s and %s", entry, prev, item)
+		}
+		found[entry] = item
+		entries[i] = entry
+	}
+
+	return entries, nil
+}
+
+func writeZip(w io.Writer, names, entries []string) error {
+	zw := zip.NewWriter(w)
+
+	for i, item := range names {
+		info, err := os.Stat(item)
+		if err != nil {
+			return errors.Wrap(err, "stat failed")
+		}
+		header, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return errors.Wrap(err, "zip failed")
+		}
+		header.Name = entries[i]
+		header.Method = zip.Deflate
+		fw, err := zw.CreateHeader(header)
+		if err != nil {
+			return errors.Wrap(err, "zip failed")
+		}
+		if err := copyFile(fw, item); err != nil {
+			return err
+		}
+	}
+
+	return errors.Wrap(zw.Close(), "zip failed")
+}
+
+func writeTarGz(w io.Writer, names, entries []string) error {
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+
+	for i, item := range names {
+		info, err := os.Stat(item)
+		if err != nil {
+			return errors.Wrap(err, "stat failed")
+		}
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return errors.Wrap(err, "tar failed")
+		}
+		header.Name = entries[i]
+		if err := tw.WriteHeader(header); err != nil {
+			return errors.Wrap(err, "tar failed")
+		}
+		if err := copyFile(tw, item); err != nil {
+			return err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return errors.Wrap(err, "tar failed")
+	}
+
+	return errors.Wrap(gw.Close(), "gzip failed")
+}
+
+func copyFile(w io.Writer, name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return errors.Wrap(err, "open failed")
+	}
+	defer func() { _ = f.Close() }()
+
+	if _, err := io.Copy(w, f); err != nil {
+		return errors.Wrap(err, "copy failed")
+	}
+
+	return nil
+}
+
+// A Stream is an attachment read from stdin or from the output of a command
+// when the message is written, so that pipelines need no temporary files.
+type Stream struct {
+	Name    string
+	Command []string // Nil for stdin
+}
+
+// parseStreams returns the streams of the stdin attachment name and of the
+// commands, format: name=cmd args. Commands are run without a shell, their
+// arguments may be quoted.
+func parseStreams(stdin string, commands []string) ([]Stream, error) {
+	var streams []Stream
+
+	if stdin != "" {
+		if err := checkStreamName(stdin); err != nil {
+			return nil, err
+		}
+		streams = append(streams, Stream{Name: stdin})
+	}
+
+	for _, item := range commands {
+		name, command, found := strings.Cut(item, "=")
+		if !found {
+			return nil, errors.Errorf("attachment command invalid: %s (want name=cmd args)", item)
+		}
+		name = strings.TrimSpace(name)
+		if err := checkStreamName(name); err != nil {
+			return nil, err
+		}
+		args, err := splitCommand(command)
+		if err != nil {
+			return nil, errors.Wrapf(err, "attachment command invalid: %s", item)
+		}
+		if len(args) == 0 {
+			return nil, errors.Errorf("attachment command empty: %s", item)
+		}
+		streams = append(streams, Stream{Name: name, Command: args})
+	}
+
+	return streams, nil
+}
+
+func checkStreamName(name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, "/\\\r\n") {
+		return errors.Errorf("attachment name invalid: %q", name)
+	}
+
+	return nil
+}
+
+// splitCommand splits a command line into arguments at spaces, except in
+// single or double quotes. Backslashes escape the next character outside single
+// quotes.
+func splitCommand(s string) ([]string, error) {
+	var args []string
+	var arg strings.Builder
+	var quote rune
+
+	inArg, escaped := false, false
+
+	for _, r := range s {
+		switch {
+		case escaped:
+			arg.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped, inArg = true, true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				arg.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote, inArg = r, true
+		case r == ' ' || r == '\t':
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(r)
+			inArg = true
+		}
+	}
+
+	if quote != 0 || escaped {
+		return nil, errors.New("unterminated quote or escape")
+	}
+
+	if inArg {
+		args = append(args, arg.String())
+	}
+
+	return args, nil
+}
+
+// copyTo copies the content of the stream to w. A failing command fails the
+// message, with its stderr in the error.
+func (s Stream) copyTo(w io.Writer) error {
+	if s.Command == nil {
+		if _, err := io.Copy(w, os.Stdin); err != nil {
+			return errors.Wrapf(err, "attachment %s failed", s.Name)
+		}
+		return nil
+	}
+
+	var stderr bytes.Buffer
+
+	cmd := exec.Command(s.Command[0], s.Command[1:]...)
+	cmd.Stderr = &stderr
+
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return errors.Wrapf(err, "attachment %s failed", s.Name)
+	}
+
+	if err := cmd.Start(); err != nil {
+		return errors.Wrapf(err, "attachment %s failed", s.Name)
+	}
+
+	if _, err := io.Copy(w, out); err != nil {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+		return errors.Wrapf(err, "attachment %s failed", s.Name)
+	}
+
+	if err := cmd.Wait(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return errors.Errorf("attachment %s failed: %s: %v: %s", s.Name, s.Command[0], err, msg)
+		}
+		return errors.Wrapf(err, "attachment %s failed: %s", s.Name, s.Command[0])
+	}
+
+	return nil
+}
+
+// streamContent reads a stream once, on the first render of the message, and
+// replays it on the later ones, such as a retry or the size check, signing and
+// encryption of the message. Stdin cannot be read twice, and commands could
+// give another output.
+type streamContent struct {
+	stream Stream
+	config *Config
+	size   *int64
+	read   bool
+	buf    bytes.Buffer
+	err    error
+}
+
+func (c *streamContent) copyTo(w io.Writer) error {
+	if !c.read {
+		c.read = true
+		c.err = c.stream.copyTo(&sizeWriter{w: &c.buf, config: c.config, size: c.size})
+	}
+
+	if c.err != nil {
+		return c.err
+	}
+
+	_, err := w.Write(c.buf.Bytes())
+
+	return err
+}
+
+// sizeWriter fails once the total size of the attachments, shared by several
+// writers, exceeds the limit of config.
+type sizeWriter struct {
+	w      io.Writer
+	config *Config
+	size   *int64
+}
+
+func (sw *sizeWriter) Write(p []byte) (int, error) {
+	*sw.size += int64(len(p))
+	if err := checkAttachmentSize(sw.config, *sw.size); err != nil {
+		return 0, err
+	}
+
+	return sw.w.Write(p)
+}
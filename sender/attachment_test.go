@@ -0,0 +1,302 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExpandAttachment(t *testing.T) {
+	names, err := expandAttachment("../test/attach*.txt")
+	if err != nil || !reflect.DeepEqual(names, []string{"../test/attach1.txt", "../test/attach2.txt"}) {
+		t.Error("FAIL")
+	}
+
+	if _, err := expandAttachment("../test/*.pdf"); err == nil || !strings.Contains(err.Error(), "matched no files") {
+		t.Error("FAIL")
+	}
+
+	if _, err := expandAttachment("../test/[attach"); err == nil {
+		t.Error("FAIL")
+	}
+
+	dir := t.TempDir()
+	if _, err := expandAttachment(dir); err == nil {
+		t.Error("FAIL")
+	}
+
+	_ = os.MkdirAll(filepath.Join(dir, "sub"), 0755)
+	_ = os.WriteFile(filepath.Join(dir, "b.txt"), []byte("b"), 0644)
+	_ = os.WriteFile(filepath.Join(dir, "sub", "a.txt"), []byte("a"), 0644)
+
+	names, err = expandAttachment(dir)
+	if err != nil || !reflect.DeepEqual(names, []string{filepath.Join(dir, "b.txt"), filepath.Join(dir, "sub", "a.txt")}) {
+		t.Error("FAIL")
+	}
+
+	if _, err := expandAttachment("attach1.txt"); err == nil {
+		t.Error("FAIL")
+	}
+}
+
+func TestParseAttachmentSize(t *testing.T) {
+	config := Config{Sep: ","}
+
+	names, err := parseAttachment(&config, "../test/attach1.txt,../test/*.txt,../test")
+	if err != nil || len(names) != 3 {
+		t.Error("FAIL")
+	}
+
+	config.Attachment.MaxSize = 1
+	if _, err := parseAttachment(&config, "../test/attach1.txt"); err == nil || !strings.Contains(err.Error(), "too large") {
+		t.Error("FAIL")
+	}
+
+	// Bundles are checked once built.
+	config.Attachment.Bundle = bundleZip
+	if _, err := parseAttachment(&config, "../test/attach1.txt"); err != nil {
+		t.Error("FAIL")
+	}
+
+	config.Attachment.Bundle = "rar"
+	if _, err := parseAttachment(&config, "../test/attach1.txt"); err == nil {
+		t.Error("FAIL")
+	}
+}
+
+func TestFormatSize(t *testing.T) {
+	if formatSize(512) != "512 B" || formatSize(1536) != "1.5 KiB" || formatSize(10*1024*1024) != "10.0 MiB" {
+		t.Error("FAIL")
+	}
+}
+
+func TestWriteBundle(t *testing.T) {
+	dir := t.TempDir()
+	_ = os.WriteFile(filepath.Join(dir, "attach1.txt"), []byte("other"), 0644)
+
+	names := []string{"../test/attach1.txt", "../test/attach2.txt"}
+
+	var buf bytes.Buffer
+	if err := writeBundle(&buf, names, bundleZip); err != nil {
+		t.Error("FAIL")
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil || len(zr.File) != 2 || zr.File[0].Name != "attach1.txt" || zr.File[1].Name != "attach2.txt" {
+		t.Fatal("FAIL")
+	}
+
+	want, _ := os.ReadFile("../test/attach1.txt")
+	f, _ := zr.File[0].Open()
+	if got, _ := io.ReadAll(f); !bytes.Equal(got, want) {
+		t.Error("FAIL")
+	}
+
+	buf.Reset()
+	if err := writeBundle(&buf, names, bundleTarGz); err != nil {
+		t.Error("FAIL")
+	}
+
+	gr, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatal("FAIL")
+	}
+	tr := tar.NewReader(gr)
+	header, err := tr.Next()
+	if err != nil || header.Name != "attach1.txt" {
+		t.Error("FAIL")
+	}
+	if got, _ := io.ReadAll(tr); !bytes.Equal(got, want) {
+		t.Error("FAIL")
+	}
+
+	if err := writeBundle(&buf, []string{"../test/attach1.txt", filepath.Join(dir, "attach1.txt")}, bundleZip); err == nil {
+		t.Error("FAIL")
+	}
+}
+
+func TestBuildMessageBundle(t *testing.T) {
+	config := Config{Attachment: AttachmentConfig{Bundle: bundleTarGz}, Sender: "mail@example.com", Sep: ","}
+
+	data := Mail{
+		[]string{"../test/attach1.txt", "../test/attach2.txt"},
+		"body",
+		nil,
+		"text/plain",
+		nil,
+		"Sender",
+		"",
+		"",
+		nil,
+		nil,
+		"Title",
+		[]string{"alen@example.com"},
+	}
+
+	msg, err := buildMessage(&config, &data)
+	if err != nil {
+		t.Fatal("FAIL")
+	}
+
+	var buf bytes.Buffer
+	if _, err := msg.WriteTo(&buf); err != nil {
+		t.Error("FAIL")
+	}
+
+	if !strings.Contains(buf.String(), `filename="attachments.tar.gz"`) || strings.Contains(buf.String(), "attach1.txt") {
+		t.Error("FAIL")
+	}
+
+	config.Attachment.MaxSize = 16
+	if _, err := buildMessage(&config, &data); err == nil || !strings.Contains(err.Error(), "too large") {
+		t.Error("FAIL")
+	}
+}
+
+func TestParseStreams(t *testing.T) {
+	streams, err := parseStreams("build.log", []string{`report.csv=sh -c "echo a,b"`, " status.txt = git status"})
+	if err != nil || !reflect.DeepEqual(streams, []Stream{
+		{Name: "build.log"},
+		{Name: "report.csv", Command: []string{"sh", "-c", "echo a,b"}},
+		{Name: "status.txt", Command: []string{"git", "status"}},
+	}) {
+		t.Error("FAIL")
+	}
+
+	if _, err := parseStreams("../build.log", nil); err == nil {
+		t.Error("FAIL")
+	}
+
+	if _, err := parseStreams("", []string{"report.csv"}); err == nil {
+		t.Error("FAIL")
+	}
+
+	if _, err := parseStreams("", []string{"report.csv= "}); err == nil {
+		t.Error("FAIL")
+	}
+
+	if _, err := parseStreams("", []string{`report.csv=echo "a`}); err == nil {
+		t.Error("FAIL")
+	}
+}
+
+func TestSplitCommand(t *testing.T) {
+	args, err := splitCommand(` echo  'a "b"' "c \"d\"" e\ f ""`)
+	if err != nil || !reflect.DeepEqual(args, []string{"echo", `a "b"`, `c "d"`, "e f", ""}) {
+		t.Error("FAIL")
+	}
+
+	if _, err := splitCommand(`echo \`); err == nil {
+		t.Error("FAIL")
+	}
+}
+
+func TestBuildMessageStreams(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not found")
+	}
+
+	config := Config{Sender: "mail@example.com", Sep: ","}
+
+	data := Mail{
+		Body:        "body",
+		ContentType: "text/plain",
+		Streams:     []Stream{{Name: "build.log", Command: []string{"sh", "-c", "echo build passed"}}},
+		Subject:     "Title",
+		To:          []string{"alen@example.com"},
+	}
+
+	msg, err := buildMessage(&config, &data)
+	if err != nil {
+		t.Fatal("FAIL")
+	}
+
+	var buf bytes.Buffer
+	if _, err := msg.WriteTo(&buf); err != nil {
+		t.Error("FAIL")
+	}
+
+	if !strings.Contains(buf.String(), `filename="build.log"`) ||
+		!strings.Contains(buf.String(), base64.StdEncoding.EncodeToString([]byte("build passed\n"))) {
+		t.Error("FAIL")
+	}
+
+	// Later renders, as by a retry, replay the output of the first one
+	dir := t.TempDir()
+	count := filepath.Join(dir, "count")
+	data.Streams[0].Command = []string{"sh", "-c", "echo run >> " + count + "; cat " + count}
+	config.Attachment.MaxSize = 1024
+	if msg, err = buildMessage(&config, &data); err != nil {
+		t.Fatal("FAIL")
+	}
+
+	var first, second bytes.Buffer
+	if _, err := msg.WriteTo(&first); err != nil {
+		t.Error("FAIL")
+	}
+	if _, err := msg.WriteTo(&second); err != nil {
+		t.Error("FAIL")
+	}
+
+	if runs, _ := os.ReadFile(count); string(runs) != "run\n" {
+		t.Error("FAIL")
+	}
+	if !strings.Contains(second.String(), base64.StdEncoding.EncodeToString([]byte("run\n"))) {
+		t.Error("FAIL")
+	}
+	config.Attachment.MaxSize = 0
+
+	// Stdin can only be read once
+	name := filepath.Join(dir, "stdin")
+	_ = os.WriteFile(name, []byte("test log\n"), 0644)
+	stdin, err := os.Open(name)
+	if err != nil {
+		t.Fatal("FAIL")
+	}
+	saved := os.Stdin
+	defer func() { os.Stdin = saved; _ = stdin.Close() }()
+	os.Stdin = stdin
+
+	data.Streams[0].Command = nil
+	if msg, err = buildMessage(&config, &data); err != nil {
+		t.Fatal("FAIL")
+	}
+	for i := 0; i < 2; i++ {
+		buf.Reset()
+		if _, err := msg.WriteTo(&buf); err != nil {
+			t.Error("FAIL")
+		}
+		if !strings.Contains(buf.String(), base64.StdEncoding.EncodeToString([]byte("test log\n"))) {
+			t.Error("FAIL")
+		}
+	}
+
+	data.Streams[0].Command = []string{"sh", "-c", "echo broken >&2; exit 3"}
+	if msg, err = buildMessage(&config, &data); err != nil {
+		t.Fatal("FAIL")
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := msg.WriteTo(io.Discard); err == nil || !strings.Contains(err.Error(), "broken") {
+			t.Error("FAIL")
+		}
+	}
+
+	data.Attachment = []string{"../test/attach1.txt"}
+	data.Streams[0].Command = []string{"sh", "-c", "yes | head -c 100000"}
+	config.Attachment.MaxSize = 50000
+	if msg, err = buildMessage(&config, &data); err != nil {
+		t.Fatal("FAIL")
+	}
+	if _, err := msg.WriteTo(io.Discard); err == nil || !strings.Contains(err.Error(), "too large") {
+		t.Error("FAIL")
+	}
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bytes"
+	"html"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	east "github.com/yuin/goldmark/extension/ast"
+	gmhtml "github.com/yuin/goldmark/renderer/html"
+	"github.com/yuin/goldmark/text"
+)
+
+var (
+	themeComment  = regexp.MustCompile(`(?s)/\*.*?\*/`)
+	themeSelector = regexp.MustCompile(`^[a-z][a-z0-9]*$`)
+	htmlTag       = regexp.MustCompile(`<([a-z][a-z0-9]*)([^>]*)>`)
+)
+
+// markdown renders GitHub flavored Markdown. Raw HTML is omitted and dangerous
+// links such as javascript: URLs are dropped, so that the HTML is safe to send.
+var markdown = goldmark.New(goldmark.WithExtensions(extension.GFM))
+
+// renderMarkdown returns the plain text and the HTML versions of a Markdown
+// body. The styles of the CSS theme, if any, are inlined in the HTML since most
+// mail clients ignore style sheets.
+func renderMarkdown(body, theme string) (string, string, error) {
+	source := []byte(body)
+	doc := markdown.Parser().Parse(text.NewReader(source))
+
+	var buf bytes.Buffer
+
+	buf.WriteString("<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n</head>\n<body>\n")
+	if err := markdown.Renderer().Render(&buf, source, doc); err != nil {
+		return "", "", errors.Wrap(err, "render failed")
+	}
+	buf.WriteString("</body>\n</html>\n")
+
+	page := buf.String()
+
+	if theme != "" {
+		css, err := os.ReadFile(theme)
+		if err != nil {
+			return "", "", errors.Wrap(err, "read failed")
+		}
+		styles, err := parseTheme(string(css))
+		if err != nil {
+			return "", "", err
+		}
+		page = inlineStyles(page, styles)
+	}
+
+	return markdownText(source, doc), page, nil
+}
+
+// parseTheme returns the declarations of a CSS theme by element. Only element
+// selectors are supported, as the styles are inlined.
+func parseTheme(css string) (map[string]string, error) {
+	styles := map[string]string{}
+
+	rules := strings.Split(themeComment.ReplaceAllString(css, ""), "}")
+	for i, rule := range rules {
+		if strings.TrimSpace(rule) == "" {
+			continue
+		}
+
+		selectors, decls, found := strings.Cut(rule, "{")
+		if !found || i == len(rules)-1 {
+			return nil, errors.Errorf("theme rule invalid: %s", strings.TrimSpace(rule))
+		}
+
+		decls = strings.Join(strings.Fields(decls), " ")
+		decls = strings.TrimSuffix(strings.TrimSpace(decls), ";")
+		if decls == "" {
+			continue
+		}
+
+		for _, item := range strings.Split(selectors, ",") {
+			item = strings.ToLower(strings.TrimSpace(item))
+			if !themeSelector.MatchString(item) {
+				return nil, errors.Errorf("theme selector unsupported: %s", item)
+			}
+			if styles[item] != "" {
+				styles[item] += "; "
+			}
+			styles[item] += decls
+		}
+	}
+
+	return styles, nil
+}
+
+// inlineStyles adds the style of its element to each tag of page.
+func inlineStyles(page string, styles map[string]string) string {
+	return htmlTag.ReplaceAllStringFunc(page, func(tag string) string {
+		match := htmlTag.FindStringSubmatch(tag)
+		style, ok := styles[match[1]]
+		if !ok {
+			return tag
+		}
+		attrs := strings.TrimSuffix(match[2], "/")
+		closing := strings.TrimPrefix(match[2], attrs)
+		return "<" + match[1] + attrs + ` style="` + html.EscapeString(style) + `"` + closing + ">"
+	})
+}
+
+// markdownText renders a Markdown document as plain text, keeping the
+// structure of lists, quotes and code blocks readable.
+func markdownText(source []byte, doc ast.Node) string {
+	return childBlocks(source, doc, "\n\n") + "\n"
+}
+
+func childBlocks(source []byte, n ast.Node, sep string) string {
+	var blocks []string
+
+	for c := n.FirstChild(); c != nil; c = c.NextSibling() {
+		if s := blockText(source, c); s != "" {
+			blocks = append(blocks, s)
+		}
+	}
+
+	return strings.Join(blocks, sep)
+}
+
+func blockText(source []byte, n ast.Node) string {
+	switch n := n.(type) {
+	case *ast.Heading:
+		title := inlineText(source, n)
+		switch n.Level {
+		case 1:
+			return title + "\n" + strings.Repeat("=", utf8.RuneCountInString(title))
+		case 2:
+			return title + "\n" + strings.Repeat("-", utf8.RuneCountInString(title))
+		}
+		return title
+	case *ast.Paragraph, *ast.TextBlock:
+		return inlineText(source, n)
+	case *ast.List:
+		sep := "\n\n"
+		if n.IsTight {
+			sep = "\n"
+		}
+		var items []string
+		number := n.Start
+		for item := n.FirstChild(); item != nil; item = item.NextSibling() {
+			marker := "- "
+			if n.IsOrdered() {
+				marker = strconv.Itoa(number) + ". "
+				number++
+			}
+			items = append(items, indentText(marker, childBlocks(source, item, sep)))
+		}
+		return strings.Join(items, sep)
+	case *ast.Blockquote:
+		return indentText("> ", childBlocks(source, n, "\n\n"))
+	case *ast.FencedCodeBlock, *ast.CodeBlock:
+		var buf strings.Builder
+		lines := n.Lines()
+		for i := 0; i < lines.Len(); i++ {
+			segment := lines.At(i)
+			buf.WriteString("    " + string(segment.Value(source)))
+		}
+		return strings.TrimRight(buf.String(), "\n")
+	case *ast.ThematicBreak:
+		return "----------"
+	case *ast.HTMLBlock:
+		return ""
+	case *east.Table:
+		var rows []string
+		for row := n.FirstChild(); row != nil; row = row.NextSibling() {
+			var cells []string
+			for cell := row.FirstChild(); cell != nil; cell = cell.NextSibling() {
+				cells = append(cells, inlineText(source, cell))
+			}
+			rows = append(rows, strings.Join(cells, " | "))
+		}
+		return strings.Join(rows, "\n")
+	}
+
+	return childBlocks(source, n, "\n\n")
+}
+
+func inlineText(source []byte, n ast.Node) string {
+	var buf strings.Builder
+
+	for c := n.FirstChild(); c != nil; c = c.NextSibling() {
+		switch c := c.(type) {
+		case *ast.Text:
+			buf.Write(c.Segment.Value(source))
+			if c.SoftLineBreak() || c.HardLineBreak() {
+				buf.WriteString("\n")
+			}
+		case *ast.String:
+			buf.Write(c.Value)
+		case *ast.Link:
+			label := inlineText(source, c)
+			if dest := string(c.Destination); dest != "" && dest != label && !gmhtml.IsDangerousURL(c.Destination) {
+				label += " (" + dest + ")"
+			}
+			buf.WriteString(label)
+		case *ast.AutoLink:
+			buf.Write(c.URL(source))
+		case *ast.RawHTML:
+		case *east.TaskCheckBox:
+			if c.IsChecked {
+				buf.WriteString("[x] ")
+			} else {
+				buf.WriteString("[ ] ")
+			}
+		default:
+			buf.WriteString(inlineText(source, c))
+		}
+	}
+
+	return buf.String()
+}
+
+// indentText prefixes the first line of s with prefix, and the other lines with
+// as many spaces, or with prefix itself for quotes.
+func indentText(prefix, s string) string {
+	lines := strings.Split(s, "\n")
+
+	for i := range lines {
+		switch {
+		case i == 0 || strings.TrimSpace(prefix) == ">":
+			lines[i] = strings.TrimRight(prefix+lines[i], " ")
+		case lines[i] != "":
+			lines[i] = strings.Repeat(" ", len(prefix)) + lines[i]
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const markdownBody = `# Build 42
+
+The build **failed** on ` + "`main`" + `, see [the log](https://ci.example.com/42).
+
+- lint
+- test
+  1. unit
+  2. e2e
+
+> Retry with care
+
+    make test
+
+| Job | Status |
+| --- | ------ |
+| lint | ok |
+
+<script>alert(1)</script>
+
+[click](javascript:alert(1))
+`
+
+func TestRenderMarkdown(t *testing.T) {
+	text, page, err := renderMarkdown(markdownBody, "")
+	if err != nil {
+		t.Fatal("FAIL")
+	}
+
+	want := `Build 42
+========
+
+The build failed on main, see the log (https://ci.example.com/42).
+
+- lint
+- test
+  1. unit
+  2. e2e
+
+> Retry with care
+
+    make test
+
+Job | Status
+lint | ok
+
+click
+`
+	if text != want {
+		t.Errorf("FAIL: %q", text)
+	}
+
+	for _, item := range []string{"<h1>Build 42</h1>", "<strong>failed</strong>", `<a href="https://ci.example.com/42">`, "<table>", "<blockquote>"} {
+		if !strings.Contains(page, item) {
+			t.Error("FAIL")
+		}
+	}
+
+	// Raw HTML and dangerous links are dropped
+	if strings.Contains(page, "<script>") || strings.Contains(page, "javascript:") {
+		t.Error("FAIL")
+	}
+
+	if strings.Contains(page, "style=") {
+		t.Error("FAIL")
+	}
+}
+
+func TestRenderMarkdownTheme(t *testing.T) {
+	dir := t.TempDir()
+	theme := filepath.Join(dir, "theme.css")
+
+	css := `/* Theme */
+body { font-family: sans-serif; color: #333 }
+h1, h2 { color: "navy"; }
+a { color: #0366d6; }
+a { text-decoration: none }
+`
+	if err := os.WriteFile(theme, []byte(css), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	_, page, err := renderMarkdown(markdownBody, theme)
+	if err != nil {
+		t.Fatal("FAIL")
+	}
+
+	for _, item := range []string{
+		`<body style="font-family: sans-serif; color: #333">`,
+		`<h1 style="color: &#34;navy&#34;">`,
+		`<a href="https://ci.example.com/42" style="color: #0366d6; text-decoration: none">`,
+		"<p>",
+	} {
+		if !strings.Contains(page, item) {
+			t.Errorf("FAIL: %s", item)
+		}
+	}
+
+	for _, item := range []string{"div.note { color: red }", "p { color: red", ".note { color: red }"} {
+		if err := os.WriteFile(theme, []byte(item), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := renderMarkdown(markdownBody, theme); err == nil {
+			t.Error("FAIL")
+		}
+	}
+
+	if _, _, err := renderMarkdown(markdownBody, filepath.Join(dir, "invalid")); err == nil {
+		t.Error("FAIL")
+	}
+}
+
+func TestBuildMessageMarkdown(t *testing.T) {
+	config := Config{Sender: "mail@example.com"}
+	data := Mail{
+		Body:        "Hello **world**",
+		ContentType: contentTypeMap["MARKDOWN"],
+		Subject:     "Markdown",
+		To:          []string{"alen@example.com"},
+	}
+
+	msg, err := buildMessage(&config, &data)
+	if err != nil {
+		t.Fatal("FAIL")
+	}
+
+	var buf strings.Builder
+	if _, err := msg.WriteTo(&buf); err != nil {
+		t.Fatal("FAIL")
+	}
+
+	got := buf.String()
+	for _, item := range []string{"multipart/alternative", "Content-Type: text/plain", "Content-Type: text/html", "Hello world", "<strong>world</strong>"} {
+		if !strings.Contains(got, item) {
+			t.Errorf("FAIL: %s", item)
+		}
+	}
+
+	config.Markdown.Theme = "invalid.css"
+	if _, err := buildMessage(&config, &data); err == nil {
+		t.Error("FAIL")
+	}
+}
@@ -1,24 +1,40 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/rand"
 	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
 	"encoding/json"
+	"encoding/pem"
 	"fmt"
 	"io"
 	"log"
 	"mime"
 	"net"
+	"net/http"
 	"net/mail"
 	"net/smtp"
+	"net/textproto"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"strings"
+	"syscall"
 	"time"
+	"unicode"
 
+	"github.com/ProtonMail/go-crypto/openpgp"
 	gomail "github.com/go-mail/mail"
 	"github.com/pkg/errors"
 	"gopkg.in/alecthomas/kingpin.v2"
+
+	"github.com/craftslab/gomail/bounce"
+	"github.com/craftslab/gomail/policy"
 )
 
 var (
@@ -27,12 +43,84 @@ var (
 )
 
 type Config struct {
-	Host   string `json:"host"`
-	Pass   string `json:"pass"`
-	Port   int    `json:"port"`
-	Sender string `json:"sender"`
-	Sep    string `json:"sep"`
-	User   string `json:"user"`
+	Attachment AttachmentConfig  `json:"attachment"`
+	Directory  DirectoryConfig   `json:"directory"`
+	DKIM       DKIMConfig        `json:"dkim"`
+	DSN        DSNConfig         `json:"dsn"`
+	Filter     []policy.Rule     `json:"filter"`
+	Headers    map[string]string `json:"headers"`
+	Host       string            `json:"host"`
+	HTTP       HTTPConfig        `json:"http"`
+	LMTP       LMTPConfig        `json:"lmtp"`
+	Markdown   MarkdownConfig    `json:"markdown"`
+	Pass       string            `json:"pass"`
+	PGP        PGPConfig         `json:"pgp"`
+	Port       int               `json:"port"`
+	Sender     string            `json:"sender"`
+	Sendmail   SendmailConfig    `json:"sendmail"`
+	Sep        string            `json:"sep"`
+	SMIME      SMIMEConfig       `json:"smime"`
+	Transport  string            `json:"transport"`
+	User       string            `json:"user"`
+}
+
+type AttachmentConfig struct {
+	Bundle  string `json:"bundle"`
+	MaxSize int64  `json:"max_size"`
+}
+
+type DirectoryConfig struct {
+	Envelope bool   `json:"envelope"`
+	Path     string `json:"path"`
+}
+
+type DKIMConfig struct {
+	Canonicalization string   `json:"canonicalization"`
+	Domain           string   `json:"domain"`
+	Headers          []string `json:"headers"`
+	PrivateKey       string   `json:"private_key"`
+	Selector         string   `json:"selector"`
+}
+
+type DSNConfig struct {
+	EnvelopeID string `json:"envelope_id"`
+	Notify     string `json:"notify"`
+	Return     string `json:"return"`
+}
+
+type HTTPConfig struct {
+	Header   map[string]string `json:"header"`
+	Template string            `json:"template"`
+	URL      string            `json:"url"`
+}
+
+type LMTPConfig struct {
+	Address string `json:"address"`
+	Network string `json:"network"`
+}
+
+type MarkdownConfig struct {
+	Theme string `json:"theme"`
+}
+
+type PGPConfig struct {
+	Keyring       string `json:"keyring"`
+	Mode          string `json:"mode"`
+	Passphrase    string `json:"passphrase"`
+	SecretKeyring string `json:"secret_keyring"`
+}
+
+type SendmailConfig struct {
+	Args []string `json:"args"`
+	Path string   `json:"path"`
+}
+
+type SMIMEConfig struct {
+	Certificate string `json:"certificate"`
+	Encrypt     bool   `json:"encrypt"`
+	PrivateKey  string `json:"private_key"`
+	Recipients  string `json:"recipients"`
+	Sign        bool   `json:"sign"`
 }
 
 type Mail struct {
@@ -40,7 +128,12 @@ type Mail struct {
 	Body        string
 	Cc          []string
 	ContentType string
+	Event       *gomail.Event
 	From        string // Sender display name (from --header option)
+	InReplyTo   string
+	MessageID   string
+	References  []string
+	Streams     []Stream
 	Subject     string
 	To          []string
 }
@@ -58,26 +151,116 @@ type ValidationResult struct {
 var (
 	contentTypeMap = map[string]string{
 		"HTML":       "text/html",
+		"MARKDOWN":   "text/markdown",
 		"PLAIN_TEXT": "text/plain",
 	}
 )
 
+const (
+	pgpNone    = "none"
+	pgpSign    = "sign"
+	pgpEncrypt = "encrypt"
+	pgpBoth    = "both"
+)
+
+const (
+	priorityHigh   = "high"
+	priorityNormal = "normal"
+	priorityLow    = "low"
+)
+
+var (
+	// X-Priority values of the priorities, with Importance set to the priority
+	priorityMap = map[string]string{
+		priorityHigh:   "1 (Highest)",
+		priorityNormal: "3 (Normal)",
+		priorityLow:    "5 (Lowest)",
+	}
+
+	// Headers set by sender itself, which custom headers cannot override
+	protectedHeaders = []string{
+		"Bcc", "Cc", "Content-Transfer-Encoding", "Content-Type", "Date", "DKIM-Signature", "From",
+		"In-Reply-To", "Message-ID", "MIME-Version", "References", "Return-Path", "Sender", "Subject", "To",
+	}
+)
+
+const (
+	transportSMTP      = "smtp"
+	transportSendmail  = "sendmail"
+	transportDirectory = "directory"
+	transportLMTP      = "lmtp"
+	transportHTTP      = "http"
+)
+
 var (
 	app = kingpin.New("sender", "Mail sender").Version(BuildTime + "-" + CommitID)
 
-	attachment  = app.Flag("attachment", "Attachment files, format: attach1,attach2,...").Short('a').String()
-	body        = app.Flag("body", "Body text or file").Short('b').String()
-	config      = app.Flag("config", "Config file, format: .json").Short('c').String()
-	contentType = app.Flag("content_type", "Content type, format: HTML or PLAIN_TEXT (default)").
-			Short('e').Default("PLAIN_TEXT").Enum("HTML", "PLAIN_TEXT")
-	header     = app.Flag("header", "Sender display name (used with sender address from config file)").Short('r').String()
-	recipients = app.Flag("recipients", "Recipients list, format: alen@example.com,cc:bob@example.com").Short('p').Required().String()
-	title      = app.Flag("title", "Title text").Short('t').String()
-	dryRun     = app.Flag("dry-run", "Only output recipient validation JSON and exit; do not send").Short('n').Bool()
+	attachCmd     = app.Flag("attach-cmd", "Attach the output of a command, format: name=cmd args (repeatable)").Strings()
+	attachStdin   = app.Flag("attach-stdin", "Attach the standard input, format: name.txt").String()
+	attachment    = app.Flag("attachment", "Attachment files, directories or patterns, format: attach1,dir1,*.pdf,...").Short('a').String()
+	attachBundle  = app.Flag("attachment-bundle", "Bundle attachments into a single archive, format: zip or tar.gz (overrides config file)").Enum(bundleZip, bundleTarGz)
+	attachMaxSize = app.Flag("attachment-max-size", "Maximum total size of attachments, format: 10MB (overrides config file)").Bytes()
+	body          = app.Flag("body", "Body text or file").Short('b').String()
+	config        = app.Flag("config", "Config file, format: .json").Short('c').String()
+	contentType   = app.Flag("content_type", "Content type, format: HTML, MARKDOWN or PLAIN_TEXT (default)").
+			Short('e').Default("PLAIN_TEXT").Enum("HTML", "MARKDOWN", "PLAIN_TEXT")
+	dsnEnvID      = app.Flag("dsn-envid", "DSN envelope identifier returned in delivery notifications (overrides config file)").String()
+	dsnNotify     = app.Flag("dsn-notify", "DSN notifications, format: success,failure,delay or never (overrides config file)").String()
+	dsnReturn     = app.Flag("dsn-ret", "DSN content of failure notifications, format: full or hdrs (overrides config file)").Enum("full", "hdrs")
+	eventEnd      = app.Flag("event-end", "Calendar event end, format: 2006-01-02T15:04 or RFC 3339 (default: one hour after start)").String()
+	eventLocation = app.Flag("event-location", "Calendar event location").String()
+	eventMethod   = app.Flag("event-method", "Calendar event method, format: request (default) or cancel").Default("request").Enum("request", "cancel")
+	eventSequence = app.Flag("event-sequence", "Calendar event sequence, increased by each update or cancellation").Int()
+	eventStart    = app.Flag("event-start", "Send a calendar invitation starting at, format: 2006-01-02T15:04 or RFC 3339").String()
+	eventUID      = app.Flag("event-uid", "Calendar event UID of an update, required to cancel (default: Message-ID of a new event)").String()
+	header        = app.Flag("header", "Sender display name (used with sender address from config file)").Short('r').String()
+	headerFields  = app.Flag("header-field", "Custom header, format: Name:Value (repeatable, overrides config file)").Strings()
+	inReplyTo     = app.Flag("in-reply-to", "Message-ID of the message replied to, format: <id@example.com>").String()
+	mbox          = app.Flag("mbox", "Append the message to the output file in mbox format").Bool()
+	messageID     = app.Flag("message-id", "Message-ID of the message, format: <id@example.com> (default: generated in the domain of the sender address)").String()
+	output        = app.Flag("output", "Write the message to file instead of sending, format: message.eml or - for stdout").Short('o').String()
+	pgpMode       = app.Flag("pgp", "OpenPGP/MIME mode, format: none, sign, encrypt or both (overrides config file)").Enum(pgpNone, pgpSign, pgpEncrypt, pgpBoth)
+	priority      = app.Flag("priority", "Priority, format: high, normal or low").Enum(priorityHigh, priorityNormal, priorityLow)
+	raw           = app.Flag("raw", "Send a pre-built message file, format: message.eml").String()
+	recipients    = app.Flag("recipients", "Recipients list, format: alen@example.com,cc:bob@example.com (overrides raw message recipients)").Short('p').String()
+	references    = app.Flag("references", "Message-IDs of the thread, format: <id1@example.com> <id2@example.com>").String()
+	timeout       = app.Flag("timeout", "Abort sending after the given duration, format: 30s (default: no limit)").Duration()
+	title         = app.Flag("title", "Title text").Short('t').String()
+	dryRun        = app.Flag("dry-run", "Only output recipient validation JSON and exit; do not send").Short('n').Bool()
+
+	_           = app.Command("send", "Send mail (default)").Default()
+	bouncesCmd  = app.Command("bounces", "Report failed deliveries from bounce messages as JSON")
+	bounceFiles = bouncesCmd.Arg("files", "Bounce message files, format: .eml or mbox").Required().Strings()
+	bounceSent  = bouncesCmd.Flag("sent", "Only report bounces of sent mail, format: file of Message-IDs, one per line").String()
 )
 
 func main() {
-	kingpin.MustParse(app.Parse(os.Args[1:]))
+	command := kingpin.MustParse(app.Parse(os.Args[1:]))
+
+	if command == bouncesCmd.FullCommand() {
+		bounces, err := parseBounces(*bounceFiles, *bounceSent)
+		if err != nil {
+			log.Println(err)
+			os.Exit(1)
+		}
+		jsonOutput, err := json.MarshalIndent(bounces, "", "  ")
+		if err != nil {
+			log.Println("Error marshaling bounces:", err)
+			os.Exit(1)
+		}
+		fmt.Println(string(jsonOutput))
+		os.Exit(0)
+	}
+
+	// Abort sending cleanly on Ctrl-C, SIGTERM (e.g. a CI job timeout) or --timeout
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
 
 	config, err := parseConfig(*config)
 	if err != nil {
@@ -85,12 +268,111 @@ func main() {
 		os.Exit(1)
 	}
 
+	if *mbox && *output == "" {
+		log.Println("mbox requires output")
+		os.Exit(1)
+	}
+
+	if *pgpMode != "" {
+		config.PGP.Mode = *pgpMode
+	}
+
+	if *raw != "" && (*dsnEnvID != "" || *dsnNotify != "" || *dsnReturn != "") {
+		log.Println("dsn requires a composed message, not raw")
+		os.Exit(1)
+	}
+
+	if *raw != "" && (*inReplyTo != "" || *messageID != "" || *references != "") {
+		log.Println("in-reply-to, message-id and references require a composed message, not raw")
+		os.Exit(1)
+	}
+
+	if *raw != "" && *eventStart != "" {
+		log.Println("event requires a composed message, not raw")
+		os.Exit(1)
+	}
+
+	if *raw != "" && (len(*attachCmd) != 0 || *attachStdin != "") {
+		log.Println("attach-cmd and attach-stdin require a composed message, not raw")
+		os.Exit(1)
+	}
+
+	if *raw != "" && (len(*headerFields) != 0 || *priority != "") {
+		log.Println("header-field and priority require a composed message, not raw")
+		os.Exit(1)
+	}
+
+	if *dsnEnvID != "" {
+		config.DSN.EnvelopeID = *dsnEnvID
+	}
+
+	if *dsnNotify != "" {
+		config.DSN.Notify = *dsnNotify
+	}
+
+	if *dsnReturn != "" {
+		config.DSN.Return = *dsnReturn
+	}
+
+	if config.Headers, err = parseHeaders(&config, *headerFields, *priority); err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+
+	if *raw != "" {
+		if *dryRun {
+			validation, err := checkRaw(&config, *raw, *recipients)
+			if err != nil {
+				log.Println(err)
+				os.Exit(1)
+			}
+			jsonOutput, err := json.MarshalIndent(validation, "", "  ")
+			if err != nil {
+				log.Println("Error marshaling validation results:", err)
+				os.Exit(1)
+			}
+			fmt.Println(string(jsonOutput))
+			os.Exit(0)
+		}
+		if *output != "" {
+			if err := writeRaw(&config, *raw, *recipients, *output, *mbox); err != nil {
+				log.Println(err)
+				os.Exit(1)
+			}
+			os.Exit(0)
+		}
+		if err := sendRaw(ctx, &config, *raw, *recipients); err != nil {
+			log.Println(err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	if *recipients == "" {
+		log.Println("recipients required")
+		os.Exit(1)
+	}
+
+	if *attachBundle != "" {
+		config.Attachment.Bundle = *attachBundle
+	}
+
+	if *attachMaxSize != 0 {
+		config.Attachment.MaxSize = int64(*attachMaxSize)
+	}
+
 	attachment, err := parseAttachment(&config, *attachment)
 	if err != nil {
 		log.Println(err)
 		os.Exit(1)
 	}
 
+	streams, err := parseStreams(*attachStdin, *attachCmd)
+	if err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+
 	body, err := parseBody(*body)
 	if err != nil {
 		log.Println(err)
@@ -103,20 +385,38 @@ func main() {
 		os.Exit(1)
 	}
 
+	inReplyTo, references, err := parseThread(*inReplyTo, *references)
+	if err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+
+	event, err := parseEvent(*eventStart, *eventEnd, *eventLocation, *eventMethod, *eventUID, *eventSequence)
+	if err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+
 	var cc, to []string
 
 	cc, to = parseRecipients(&config, *recipients)
 
-	// Validate and filter recipients (unless in dry-run mode which does its own validation)
+	if err := checkPolicy(&config, append(append([]string{}, to...), cc...)); err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+
+	// Validate and filter recipients (unless in dry-run mode which does its own validation).
+	// SMTP probing is skipped when the message is written to a file.
 	if !*dryRun {
 		var validCc, validTo []string
 		for _, addr := range cc {
-			if isValidEmail(addr) && smtpRecipientExists(&config, addr) {
+			if isValidEmail(addr) && (*output != "" || smtpRecipientExists(&config, addr)) {
 				validCc = append(validCc, addr)
 			}
 		}
 		for _, addr := range to {
-			if isValidEmail(addr) && smtpRecipientExists(&config, addr) {
+			if isValidEmail(addr) && (*output != "" || smtpRecipientExists(&config, addr)) {
 				validTo = append(validTo, addr)
 			}
 		}
@@ -139,44 +439,30 @@ func main() {
 		os.Exit(1)
 	}
 
+	id, err := newMessageID(&config, *messageID)
+	if err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+
 	m := Mail{
 		attachment,
 		body,
 		cc,
 		contentType,
+		event,
 		*header,
+		inReplyTo,
+		id,
+		references,
+		streams,
 		*title,
 		to,
 	}
 
 	// In dry-run mode, output validation JSON (SMTP recipient checks when possible) and exit without sending
 	if *dryRun {
-		all := append([]string{}, cc...)
-		all = append(all, to...)
-		all = removeDuplicates(all)
-		var validAddrs []string
-		var invalidAddrs []string
-		for _, addr := range all {
-			if !isValidEmail(addr) {
-				invalidAddrs = append(invalidAddrs, addr)
-				continue
-			}
-			if smtpRecipientExists(&config, addr) {
-				validAddrs = append(validAddrs, addr)
-			} else {
-				invalidAddrs = append(invalidAddrs, addr)
-			}
-		}
-		// Build JSON result; cc/to remain as parsed, valid/invalid reflect checks
-		validation := ValidationResult{
-			ValidAddresses:   removeDuplicates(validAddrs),
-			InvalidAddresses: removeDuplicates(invalidAddrs),
-			CcAddresses:      cc,
-			ToAddresses:      to,
-			TotalCount:       len(all),
-			ValidCount:       len(removeDuplicates(validAddrs)),
-			InvalidCount:     len(removeDuplicates(invalidAddrs)),
-		}
+		validation := validateRecipients(&config, cc, to)
 		jsonOutput, err := json.MarshalIndent(validation, "", "  ")
 		if err != nil {
 			log.Println("Error marshaling validation results:", err)
@@ -186,11 +472,26 @@ func main() {
 		os.Exit(0)
 	}
 
-	if err := sendMail(&config, &m); err != nil {
+	if *output != "" {
+		if err := writeMail(&config, &m, *output, *mbox); err != nil {
+			log.Println(err)
+			os.Exit(1)
+		}
+		// The message itself holds the Message-ID when written to stdout
+		if *output != "-" {
+			fmt.Println(m.MessageID)
+		}
+		os.Exit(0)
+	}
+
+	if err := sendMail(ctx, &config, &m); err != nil {
 		log.Println(err)
 		os.Exit(1)
 	}
 
+	// Print the Message-ID so that follow-ups can reply to the message
+	fmt.Println(m.MessageID)
+
 	os.Exit(0)
 }
 
@@ -213,19 +514,46 @@ func parseConfig(name string) (Config, error) {
 }
 
 func parseAttachment(config *Config, name string) ([]string, error) {
-	var err error
 	var names []string
 
 	if name == "" {
 		return names, nil
 	}
 
-	names = strings.Split(name, config.Sep)
-	for i := 0; i < len(names); i++ {
-		names[i], err = checkFile(names[i])
+	switch config.Attachment.Bundle {
+	case "", bundleZip, bundleTarGz:
+	default:
+		return nil, errors.Errorf("attachment bundle invalid: %s (want zip or tar.gz)", config.Attachment.Bundle)
+	}
+
+	found := map[string]bool{}
+
+	for _, item := range strings.Split(name, config.Sep) {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		buf, err := expandAttachment(item)
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range buf {
+			if !found[file] {
+				found[file] = true
+				names = append(names, file)
+			}
+		}
+	}
+
+	// Bundles are checked once built, as they are compressed.
+	if config.Attachment.Bundle == "" {
+		size, err := attachmentSize(names)
 		if err != nil {
 			return nil, err
 		}
+		if err := checkAttachmentSize(config, size); err != nil {
+			return nil, err
+		}
 	}
 
 	return names, nil
@@ -254,6 +582,183 @@ func parseContentType(data string) (string, error) {
 	return buf, nil
 }
 
+// parseHeaders merges the custom headers of the config with the header fields,
+// which override them, and with the headers of the priority. Header names and
+// values are checked so that no other header can be injected.
+func parseHeaders(config *Config, fields []string, priority string) (map[string]string, error) {
+	headers := map[string]string{}
+
+	add := func(name, value string) error {
+		name = strings.TrimSpace(name)
+		if name == "" || strings.IndexFunc(name, func(r rune) bool { return r <= ' ' || r > '~' || r == ':' }) != -1 {
+			return errors.Errorf("header name invalid: %q", name)
+		}
+		if strings.ContainsAny(value, "\r\n") {
+			return errors.Errorf("header value invalid: %s", name)
+		}
+		name = textproto.CanonicalMIMEHeaderKey(name)
+		for _, item := range protectedHeaders {
+			if name == textproto.CanonicalMIMEHeaderKey(item) {
+				return errors.Errorf("header protected: %s", name)
+			}
+		}
+		headers[name] = strings.TrimSpace(value)
+		return nil
+	}
+
+	for name, value := range config.Headers {
+		if err := add(name, value); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, item := range fields {
+		name, value, found := strings.Cut(item, ":")
+		if !found {
+			return nil, errors.Errorf("header field invalid: %s", item)
+		}
+		if err := add(name, value); err != nil {
+			return nil, err
+		}
+	}
+
+	if priority != "" {
+		headers["X-Priority"] = priorityMap[priority]
+		headers["Importance"] = priority
+	}
+
+	return headers, nil
+}
+
+// parseEvent returns the calendar event to invite the recipients to, or nil if
+// there is none. Times without a time zone are local.
+func parseEvent(start, end, location, method, uid string, sequence int) (*gomail.Event, error) {
+	if start == "" {
+		if end != "" || location != "" || uid != "" || sequence != 0 || strings.EqualFold(method, gomail.CalendarCancel) {
+			return nil, errors.New("event start required")
+		}
+		return nil, nil
+	}
+
+	event := &gomail.Event{
+		Location: location,
+		Method:   strings.ToUpper(method),
+		Sequence: sequence,
+		UID:      uid,
+	}
+
+	var err error
+
+	if event.Start, err = parseEventTime(start); err != nil {
+		return nil, err
+	}
+
+	event.End = event.Start.Add(time.Hour)
+	if end != "" {
+		if event.End, err = parseEventTime(end); err != nil {
+			return nil, err
+		}
+	}
+
+	if event.End.Before(event.Start) {
+		return nil, errors.New("event end before start")
+	}
+
+	// A new UID would match no event to cancel.
+	if event.Method == gomail.CalendarCancel && uid == "" {
+		return nil, errors.New("event uid required to cancel")
+	}
+
+	return event, nil
+}
+
+func parseEventTime(data string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, data); err == nil {
+		return t, nil
+	}
+
+	for _, layout := range []string{"2006-01-02T15:04", "2006-01-02 15:04"} {
+		if t, err := time.ParseInLocation(layout, data, time.Local); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, errors.Errorf("event time invalid: %s", data)
+}
+
+// parseThread returns the In-Reply-To and References of a follow-up message.
+// The replied message ends the references as recommended by RFC 5322.
+func parseThread(inReplyTo, references string) (string, []string, error) {
+	parent, err := parseMessageIDs(inReplyTo)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if len(parent) > 1 {
+		return "", nil, errors.New("in-reply-to requires a single message id")
+	}
+
+	thread, err := parseMessageIDs(references)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if len(parent) == 0 {
+		return "", thread, nil
+	}
+
+	for _, item := range thread {
+		if item == parent[0] {
+			return parent[0], thread, nil
+		}
+	}
+
+	return parent[0], append(thread, parent[0]), nil
+}
+
+// parseMessageIDs parses a list of Message-IDs separated by spaces or commas.
+// Angle brackets are added if missing.
+func parseMessageIDs(data string) ([]string, error) {
+	var ids []string
+
+	for _, item := range strings.FieldsFunc(data, func(r rune) bool { return r == ',' || unicode.IsSpace(r) }) {
+		id := strings.TrimSuffix(strings.TrimPrefix(item, "<"), ">")
+		if i := strings.Index(id, "@"); i <= 0 || i == len(id)-1 || strings.ContainsAny(id, "<>") {
+			return nil, errors.Errorf("message id invalid: %s", item)
+		}
+		ids = append(ids, "<"+id+">")
+	}
+
+	return ids, nil
+}
+
+// newMessageID returns the given Message-ID, with angle brackets added if
+// missing, or else a unique Message-ID in the domain of the sender address.
+func newMessageID(config *Config, id string) (string, error) {
+	if id != "" {
+		ids, err := parseMessageIDs(id)
+		if err != nil {
+			return "", err
+		}
+		if len(ids) != 1 {
+			return "", errors.New("message-id requires a single message id")
+		}
+		return ids[0], nil
+	}
+
+	domain := "localhost"
+	if i := strings.LastIndex(config.Sender, "@"); i != -1 && i != len(config.Sender)-1 {
+		domain = config.Sender[i+1:]
+	}
+
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return "", errors.Wrap(err, "message id failed")
+	}
+
+	return fmt.Sprintf("<%d.%s@%s>", time.Now().UnixNano(), hex.EncodeToString(buf), domain), nil
+}
+
 func parseRecipients(config *Config, data string) (cc, to []string) {
 	buf := strings.Split(data, config.Sep)
 	for _, item := range buf {
@@ -329,6 +834,34 @@ func parseRecipientsWithValidation(config *Config, data string) (cc, to []string
 	return cc, to, validation
 }
 
+// checkPolicy refuses the whole message if any recipient is rejected by the filter rules in config.
+func checkPolicy(config *Config, recipients []string) error {
+	p, err := policy.New(config.Filter)
+	if err != nil {
+		return errors.Wrap(err, "policy failed")
+	}
+
+	var refused []string
+
+	for _, item := range recipients {
+		address := item
+		if addr, err := mail.ParseAddress(item); err == nil {
+			address = addr.Address
+		} else if addr, err := parseAddressWithTrailingDot(item); err == nil {
+			address = addr
+		}
+		if err := p.Check(address); err != nil {
+			refused = append(refused, fmt.Sprintf("%s (%v)", item, err))
+		}
+	}
+
+	if len(refused) != 0 {
+		return errors.Errorf("recipients refused by filter: %s", strings.Join(refused, ", "))
+	}
+
+	return nil
+}
+
 func isValidEmail(email string) bool {
 	// Use the same validation logic as the mail library
 	// This validates the email format according to RFC 5322 standards
@@ -386,14 +919,52 @@ func isValidEmailWithSMTP(config *Config, email string) bool {
 // It returns false only when the server clearly rejects the recipient (e.g., 550 no such user).
 // On connection/TLS/auth errors, it returns true to avoid false negatives in environments
 // where validation is not allowed.
-func smtpRecipientExists(config *Config, email string) bool {
-	if !isValidEmail(email) {
-		return false
+// validateRecipients checks the recipients of a dry run, by SMTP when possible.
+// The cc and to addresses are reported as parsed.
+func validateRecipients(config *Config, cc, to []string) ValidationResult {
+	all := append([]string{}, cc...)
+	all = append(all, to...)
+	all = removeDuplicates(all)
+
+	var validAddrs []string
+	var invalidAddrs []string
+
+	for _, addr := range all {
+		if !isValidEmail(addr) {
+			invalidAddrs = append(invalidAddrs, addr)
+			continue
+		}
+		if smtpRecipientExists(config, addr) {
+			validAddrs = append(validAddrs, addr)
+		} else {
+			invalidAddrs = append(invalidAddrs, addr)
+		}
 	}
 
-	address := net.JoinHostPort(config.Host, fmt.Sprintf("%d", config.Port))
-
-	// Try implicit TLS first when port is 465
+	return ValidationResult{
+		ValidAddresses:   removeDuplicates(validAddrs),
+		InvalidAddresses: removeDuplicates(invalidAddrs),
+		CcAddresses:      cc,
+		ToAddresses:      to,
+		TotalCount:       len(all),
+		ValidCount:       len(removeDuplicates(validAddrs)),
+		InvalidCount:     len(removeDuplicates(invalidAddrs)),
+	}
+}
+
+func smtpRecipientExists(config *Config, email string) bool {
+	if !isValidEmail(email) {
+		return false
+	}
+
+	// Local transports hand the message to the MTA, which does its own checks
+	if !isSMTP(config) {
+		return true
+	}
+
+	address := net.JoinHostPort(config.Host, fmt.Sprintf("%d", config.Port))
+
+	// Try implicit TLS first when port is 465
 	if config.Port == 465 {
 		tlsConn, err := tls.Dial("tcp", address, &tls.Config{ServerName: config.Host})
 		if err == nil {
@@ -477,29 +1048,28 @@ func rcptAcceptedTLS(tlsConn net.Conn, config *Config, email string) bool {
 	return true
 }
 
-func sendMail(config *Config, data *Mail) error {
-	msg := gomail.NewMessage()
-	// Set From header: config.Sender as email address, data.From (--header) as display name
-	// Result format: "Display Name" <sender@example.com> or sender@example.com (if no display name)
-	msg.SetAddressHeader("From", config.Sender, data.From)
-	msg.SetHeader("Cc", data.Cc...)
-	msg.SetHeader("Subject", data.Subject)
-	msg.SetHeader("To", data.To...)
-	msg.SetBody(data.ContentType, data.Body)
-
-	for _, item := range data.Attachment {
-		msg.Attach(item, gomail.Rename(mime.QEncoding.Encode("utf-8", filepath.Base(item))))
+func sendMail(ctx context.Context, config *Config, data *Mail) error {
+	msg, err := buildMessage(config, data)
+	if err != nil {
+		return err
 	}
 
-	dialer := gomail.NewDialer(config.Host, config.Port, config.User, config.Pass)
+	sender, err := newSender(ctx, config)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = sender.Close() }()
 
-	if err := dialer.DialAndSend(msg); err != nil {
+	if err := gomail.SendContext(ctx, sender, msg); err != nil {
+		if ctx.Err() != nil {
+			return errors.Wrap(err, "send aborted")
+		}
 		// Check if this is a recipient validation error
 		errStr := strings.ToLower(err.Error())
-		if strings.Contains(errStr, "no such user") ||
+		if isSMTP(config) && (strings.Contains(errStr, "no such user") ||
 			strings.Contains(errStr, "user unknown") ||
 			strings.Contains(errStr, "recipient rejected") ||
-			strings.Contains(errStr, "550") {
+			strings.Contains(errStr, "550")) {
 			// Try to identify which specific recipients are invalid
 			invalidRecipients, _ := identifyInvalidRecipients(config, data)
 			if len(invalidRecipients) > 0 {
@@ -515,6 +1085,692 @@ func sendMail(config *Config, data *Mail) error {
 	return nil
 }
 
+// sendRaw relays a pre-built message unchanged. The envelope is derived from
+// its header unless recipients are given.
+// readRaw reads a pre-built message and returns it with its envelope sender and
+// recipients, which recipients overrides if given. The recipients are checked
+// against the filter rules.
+func readRaw(config *Config, name, recipients string) (*gomail.RawMessage, string, []string, error) {
+	buf, err := os.ReadFile(name)
+	if err != nil {
+		return nil, "", nil, errors.Wrap(err, "read failed")
+	}
+
+	msg, err := gomail.NewRawMessage(buf)
+	if err != nil {
+		return nil, "", nil, errors.Wrap(err, "parse failed")
+	}
+
+	from, to, err := msg.Envelope()
+	if err != nil && recipients == "" {
+		return nil, "", nil, errors.Wrap(err, "envelope failed")
+	}
+
+	if recipients != "" {
+		cc, list := parseRecipients(config, recipients)
+		to = append(list, cc...)
+	}
+
+	if err := checkPolicy(config, to); err != nil {
+		return nil, "", nil, err
+	}
+
+	return msg, from, to, nil
+}
+
+// checkRaw validates a pre-built message and its recipients without sending it.
+func checkRaw(config *Config, name, recipients string) (ValidationResult, error) {
+	_, _, to, err := readRaw(config, name, recipients)
+	if err != nil {
+		return ValidationResult{}, err
+	}
+
+	return validateRecipients(config, nil, to), nil
+}
+
+// writeRaw writes a pre-built message to the named file as it would be sent,
+// that is unchanged except for its Bcc fields.
+func writeRaw(config *Config, name, recipients, output string, mbox bool) error {
+	msg, from, _, err := readRaw(config, name, recipients)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+
+	if _, err := msg.WriteTo(&buf); err != nil {
+		return errors.Wrap(err, "render failed")
+	}
+
+	out := buf.Bytes()
+	if mbox {
+		out = mboxMessage(from, time.Now(), out)
+	}
+
+	return writeOutput(output, out, mbox)
+}
+
+func sendRaw(ctx context.Context, config *Config, name, recipients string) error {
+	msg, _, to, err := readRaw(config, name, recipients)
+	if err != nil {
+		return err
+	}
+
+	// sendmail -t reads the recipients from the message instead
+	if recipients != "" && config.Transport == transportSendmail && (config.Sendmail.Args == nil || slices.Contains(config.Sendmail.Args, "-t")) {
+		return errors.New("recipients require sendmail args without -t")
+	}
+
+	sender, err := newSender(ctx, config)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = sender.Close() }()
+
+	if err := gomail.SendRawContext(ctx, sender, msg, to...); err != nil {
+		if ctx.Err() != nil {
+			return errors.Wrap(err, "send aborted")
+		}
+		return errors.Wrap(err, "send failed")
+	}
+
+	return nil
+}
+
+// newSender returns the sender of the configured transport. SMTP is used by
+// default, sendmail, directory and lmtp hand the message to a local MTA or
+// delivery agent instead, and http posts it to a mail API.
+func newSender(ctx context.Context, config *Config) (gomail.SendCloser, error) {
+	switch config.Transport {
+	case "", transportSMTP:
+		dialer := gomail.NewDialer(config.Host, config.Port, config.User, config.Pass)
+		sender, err := dialer.DialContext(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, errors.Wrap(err, "send aborted")
+			}
+			return nil, errors.Wrap(err, "send failed")
+		}
+		return sender, nil
+	case transportSendmail:
+		return &gomail.SendmailSender{Path: config.Sendmail.Path, Args: config.Sendmail.Args}, nil
+	case transportDirectory:
+		if config.Directory.Path == "" {
+			return nil, errors.New("directory path required")
+		}
+		return &gomail.DirectorySender{Dir: config.Directory.Path, Envelope: config.Directory.Envelope}, nil
+	case transportLMTP:
+		if config.LMTP.Address == "" {
+			return nil, errors.New("lmtp address required")
+		}
+		dialer := gomail.NewLMTPDialer(config.LMTP.Network, config.LMTP.Address)
+		sender, err := dialer.DialContext(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, errors.Wrap(err, "send aborted")
+			}
+			return nil, errors.Wrap(err, "send failed")
+		}
+		return sender, nil
+	case transportHTTP:
+		if config.HTTP.URL == "" {
+			return nil, errors.New("http url required")
+		}
+		header := http.Header{}
+		for k, v := range config.HTTP.Header {
+			header.Set(k, v)
+		}
+		return &gomail.HTTPSender{URL: config.HTTP.URL, Header: header, Template: config.HTTP.Template}, nil
+	default:
+		return nil, errors.Errorf("invalid transport %q", config.Transport)
+	}
+}
+
+func isSMTP(config *Config) bool {
+	return config.Transport == "" || config.Transport == transportSMTP
+}
+
+func buildMessage(config *Config, data *Mail) (*gomail.Message, error) {
+	var settings []gomail.MessageSetting
+
+	signer, err := parseDKIM(config)
+	if err != nil {
+		return nil, err
+	}
+
+	if signer != nil {
+		settings = append(settings, gomail.SetDKIM(signer))
+	}
+
+	smime, err := parseSMIME(config, append(append([]string{}, data.To...), data.Cc...))
+	if err != nil {
+		return nil, err
+	}
+
+	if smime != nil {
+		settings = append(settings, gomail.SetSMIME(smime))
+	}
+
+	pgp, err := parsePGP(config, append(append([]string{}, data.To...), data.Cc...))
+	if err != nil {
+		return nil, err
+	}
+
+	if pgp != nil {
+		if smime != nil {
+			return nil, errors.New("smime and pgp are exclusive")
+		}
+		settings = append(settings, gomail.SetPGP(pgp))
+	}
+
+	dsn, err := parseDSN(config)
+	if err != nil {
+		return nil, err
+	}
+
+	if dsn != nil {
+		settings = append(settings, gomail.SetDSN(dsn))
+	}
+
+	msg := gomail.NewMessage(settings...)
+	// Set From header: config.Sender as email address, data.From (--header) as display name
+	// Result format: "Display Name" <sender@example.com> or sender@example.com (if no display name)
+	msg.SetAddressHeader("From", config.Sender, data.From)
+	msg.SetHeader("Cc", data.Cc...)
+	msg.SetHeader("Subject", data.Subject)
+	msg.SetHeader("To", data.To...)
+
+	if data.MessageID != "" {
+		msg.SetHeader("Message-ID", data.MessageID)
+	}
+
+	if data.InReplyTo != "" {
+		msg.SetHeader("In-Reply-To", data.InReplyTo)
+	}
+
+	if len(data.References) != 0 {
+		msg.SetHeader("References", strings.Join(data.References, " "))
+	}
+
+	for name, value := range config.Headers {
+		msg.SetHeader(name, value)
+	}
+
+	description := data.Body
+
+	switch data.ContentType {
+	case contentTypeMap["MARKDOWN"]:
+		// Clients unable to show HTML fall back to the plain text
+		text, html, err := renderMarkdown(data.Body, config.Markdown.Theme)
+		if err != nil {
+			return nil, err
+		}
+		msg.SetBody(contentTypeMap["PLAIN_TEXT"], text)
+		msg.AddAlternative(contentTypeMap["HTML"], html)
+		description = text
+	case contentTypeMap["HTML"]:
+		msg.SetBody(data.ContentType, data.Body)
+		description = ""
+	default:
+		msg.SetBody(data.ContentType, data.Body)
+	}
+
+	if data.Event != nil {
+		// The invitation is described by the mail: the sender organizes it,
+		// To recipients are required and Cc recipients optional attendees.
+		event := *data.Event
+		if event.UID == "" {
+			event.UID = strings.Trim(data.MessageID, "<>")
+		}
+		event.Summary = data.Subject
+		event.Description = description
+		event.Organizer = msg.FormatAddress(config.Sender, data.From)
+		event.Attendees = data.To
+		event.OptionalAttendees = data.Cc
+		msg.AddCalendar(&event)
+	}
+
+	var size int64
+
+	if config.Attachment.Bundle != "" && len(data.Attachment) != 0 {
+		var buf bytes.Buffer
+		if err := writeBundle(&buf, data.Attachment, config.Attachment.Bundle); err != nil {
+			return nil, err
+		}
+		size = int64(buf.Len())
+		if err := checkAttachmentSize(config, size); err != nil {
+			return nil, err
+		}
+		msg.Attach("attachments."+config.Attachment.Bundle, gomail.SetCopyFunc(func(w io.Writer) error {
+			_, err := w.Write(buf.Bytes())
+			return err
+		}))
+	} else {
+		for _, item := range data.Attachment {
+			msg.Attach(item, gomail.Rename(mime.QEncoding.Encode("utf-8", filepath.Base(item))))
+		}
+		if size, err = attachmentSize(data.Attachment); err != nil {
+			return nil, err
+		}
+	}
+
+	// Streams are read when the message is first written, which fails if they
+	// exceed the size left by the attachments.
+	for _, item := range data.Streams {
+		content := &streamContent{stream: item, config: config, size: &size}
+		msg.Attach(item.Name, gomail.Rename(mime.QEncoding.Encode("utf-8", item.Name)),
+			gomail.SetCopyFunc(content.copyTo))
+	}
+
+	return msg, nil
+}
+
+// writeMail renders the message to the named file, or to stdout if name is "-",
+// instead of sending it. In mbox mode the message is appended to the file.
+func writeMail(config *Config, data *Mail, name string, mbox bool) error {
+	msg, err := buildMessage(config, data)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+
+	if _, err := msg.WriteTo(&buf); err != nil {
+		return errors.Wrap(err, "render failed")
+	}
+
+	out := buf.Bytes()
+	if mbox {
+		out = mboxMessage(config.Sender, time.Now(), out)
+	}
+
+	return writeOutput(name, out, mbox)
+}
+
+// writeOutput writes a message to the named file, or to stdout if name is "-".
+// Mbox files are appended to.
+func writeOutput(name string, out []byte, mbox bool) error {
+	if name == "-" {
+		_, err := os.Stdout.Write(out)
+		return errors.Wrap(err, "write failed")
+	}
+
+	flag := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if mbox {
+		flag = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	}
+
+	fi, err := os.OpenFile(name, flag, 0644)
+	if err != nil {
+		return errors.Wrap(err, "open failed")
+	}
+
+	if _, err := fi.Write(out); err != nil {
+		_ = fi.Close()
+		return errors.Wrap(err, "write failed")
+	}
+
+	return errors.Wrap(fi.Close(), "close failed")
+}
+
+// mboxMessage converts msg to an mboxrd entry: a "From " separator line, LF
+// line endings, quoted "From " lines and a trailing empty line.
+func mboxMessage(sender string, date time.Time, msg []byte) []byte {
+	if sender == "" {
+		sender = "MAILER-DAEMON"
+	}
+
+	var buf bytes.Buffer
+
+	buf.WriteString("From " + sender + " " + date.UTC().Format(time.ANSIC) + "\n")
+
+	lines := strings.Split(strings.ReplaceAll(string(msg), "\r\n", "\n"), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	for _, item := range lines {
+		if strings.HasPrefix(strings.TrimLeft(item, ">"), "From ") {
+			buf.WriteString(">")
+		}
+		buf.WriteString(item + "\n")
+	}
+
+	buf.WriteString("\n")
+
+	return buf.Bytes()
+}
+
+// parseBounces returns the failed or delayed deliveries reported in the named
+// files. If sent is given, only the bounces of the messages whose Message-IDs
+// it lists are kept.
+func parseBounces(names []string, sent string) ([]bounce.Bounce, error) {
+	bounces := []bounce.Bounce{}
+
+	for _, item := range names {
+		buf, err := bounce.ParseFile(item)
+		if err != nil {
+			return nil, errors.Wrap(err, item)
+		}
+		bounces = append(bounces, bounce.Failed(buf)...)
+	}
+
+	if sent == "" {
+		return bounces, nil
+	}
+
+	buf, err := os.ReadFile(sent)
+	if err != nil {
+		return nil, errors.Wrap(err, "read failed")
+	}
+
+	return bounce.Correlate(bounces, strings.Split(string(buf), "\n")), nil
+}
+
+// parseDKIM returns nil if no DKIM private key is configured.
+func parseDKIM(config *Config) (*gomail.DKIMSigner, error) {
+	if config.DKIM.PrivateKey == "" {
+		return nil, nil
+	}
+
+	buf, err := os.ReadFile(config.DKIM.PrivateKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "read failed")
+	}
+
+	key, err := gomail.ParseDKIMPrivateKey(buf)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse failed")
+	}
+
+	domain := config.DKIM.Domain
+	if domain == "" {
+		if index := strings.LastIndex(config.Sender, "@"); index != -1 {
+			domain = config.Sender[index+1:]
+		}
+	}
+
+	canon := strings.SplitN(config.DKIM.Canonicalization, "/", 2)
+	if len(canon) == 1 {
+		canon = append(canon, canon[0])
+	}
+
+	signer, err := gomail.NewDKIMSigner(gomail.DKIMOptions{
+		Domain:                 domain,
+		Selector:               config.DKIM.Selector,
+		Signer:                 key,
+		HeaderCanonicalization: gomail.Canonicalization(canon[0]),
+		BodyCanonicalization:   gomail.Canonicalization(canon[1]),
+		Headers:                config.DKIM.Headers,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "dkim failed")
+	}
+
+	return signer, nil
+}
+
+// parseSMIME returns nil if neither signing nor encryption is enabled. Recipient
+// certificates are looked up by e-mail address in the configured directory.
+func parseSMIME(config *Config, recipients []string) (*gomail.SMIME, error) {
+	if !config.SMIME.Sign && !config.SMIME.Encrypt {
+		return nil, nil
+	}
+
+	smime := &gomail.SMIME{}
+
+	var cert *x509.Certificate
+
+	if config.SMIME.Certificate != "" {
+		pair, err := tls.LoadX509KeyPair(config.SMIME.Certificate, config.SMIME.PrivateKey)
+		if err != nil {
+			return nil, errors.Wrap(err, "load failed")
+		}
+		for i, item := range pair.Certificate {
+			c, err := x509.ParseCertificate(item)
+			if err != nil {
+				return nil, errors.Wrap(err, "parse failed")
+			}
+			if i == 0 {
+				cert = c
+			} else {
+				smime.Chain = append(smime.Chain, c)
+			}
+		}
+		if config.SMIME.Sign {
+			smime.Certificate = cert
+			smime.PrivateKey = pair.PrivateKey
+		}
+	} else if config.SMIME.Sign {
+		return nil, errors.New("certificate null")
+	}
+
+	if !config.SMIME.Encrypt {
+		return smime, nil
+	}
+
+	certs, err := loadCertificates(config.SMIME.Recipients)
+	if err != nil {
+		return nil, err
+	}
+
+	var missing []string
+
+	for _, item := range recipients {
+		address := item
+		if addr, err := mail.ParseAddress(item); err == nil {
+			address = addr.Address
+		}
+		found := false
+		for _, c := range certs {
+			for _, email := range c.EmailAddresses {
+				if strings.EqualFold(email, address) {
+					smime.Recipients = append(smime.Recipients, c)
+					found = true
+					break
+				}
+			}
+			if found {
+				break
+			}
+		}
+		if !found {
+			missing = append(missing, address)
+		}
+	}
+
+	if len(missing) != 0 {
+		return nil, errors.Errorf("certificate not found: %s", strings.Join(missing, ", "))
+	}
+
+	// Keep the message readable by the sender
+	if cert != nil {
+		smime.Recipients = append(smime.Recipients, cert)
+	}
+
+	return smime, nil
+}
+
+// parsePGP returns nil if OpenPGP/MIME is disabled. The signing key is looked up
+// by the sender address and recipient keys by e-mail address in the keyrings.
+func parsePGP(config *Config, recipients []string) (*gomail.PGP, error) {
+	var sign, encrypt bool
+
+	switch config.PGP.Mode {
+	case "", pgpNone:
+		return nil, nil
+	case pgpSign:
+		sign = true
+	case pgpEncrypt:
+		encrypt = true
+	case pgpBoth:
+		sign, encrypt = true, true
+	default:
+		return nil, errors.Errorf("pgp mode invalid: %s", config.PGP.Mode)
+	}
+
+	pgp := &gomail.PGP{}
+
+	if sign {
+		keyring, err := loadKeyring(config.PGP.SecretKeyring)
+		if err != nil {
+			return nil, err
+		}
+		signer := findEntity(keyring, config.Sender)
+		if signer == nil || signer.PrivateKey == nil {
+			return nil, errors.Errorf("secret key not found: %s", config.Sender)
+		}
+		if err := signer.DecryptPrivateKeys([]byte(config.PGP.Passphrase)); err != nil {
+			return nil, errors.Wrap(err, "decrypt failed")
+		}
+		pgp.Signer = signer
+	}
+
+	if !encrypt {
+		return pgp, nil
+	}
+
+	keyring, err := loadKeyring(config.PGP.Keyring)
+	if err != nil {
+		return nil, err
+	}
+
+	var missing []string
+
+	for _, item := range recipients {
+		address := item
+		if addr, err := mail.ParseAddress(item); err == nil {
+			address = addr.Address
+		}
+		if entity := findEntity(keyring, address); entity != nil {
+			pgp.Recipients = append(pgp.Recipients, entity)
+		} else {
+			missing = append(missing, address)
+		}
+	}
+
+	if len(missing) != 0 {
+		return nil, errors.Errorf("public key not found: %s", strings.Join(missing, ", "))
+	}
+
+	// Keep the message readable by the sender
+	if pgp.Signer != nil {
+		pgp.Recipients = append(pgp.Recipients, pgp.Signer)
+	} else if entity := findEntity(keyring, config.Sender); entity != nil {
+		pgp.Recipients = append(pgp.Recipients, entity)
+	}
+
+	return pgp, nil
+}
+
+// parseDSN returns the delivery status notifications requested in the config,
+// or nil if none is. The recipients are reported as original recipients so
+// that notifications of forwarded messages can be matched.
+func parseDSN(config *Config) (*gomail.DSN, error) {
+	if config.DSN == (DSNConfig{}) {
+		return nil, nil
+	}
+
+	dsn := &gomail.DSN{
+		EnvelopeID:        config.DSN.EnvelopeID,
+		OriginalRecipient: true,
+	}
+
+	switch ret := strings.ToUpper(config.DSN.Return); ret {
+	case "":
+	case gomail.DSNReturnFull, gomail.DSNReturnHeaders:
+		dsn.Return = ret
+	default:
+		return nil, errors.Errorf("dsn return invalid: %s", config.DSN.Return)
+	}
+
+	if config.DSN.Notify == "" {
+		return dsn, nil
+	}
+
+	for _, item := range strings.Split(config.DSN.Notify, ",") {
+		item = strings.ToUpper(strings.TrimSpace(item))
+		switch item {
+		case gomail.DSNNotifySuccess, gomail.DSNNotifyFailure, gomail.DSNNotifyDelay, gomail.DSNNotifyNever:
+			dsn.Notify = append(dsn.Notify, item)
+		default:
+			return nil, errors.Errorf("dsn notify invalid: %s", item)
+		}
+	}
+
+	for _, item := range dsn.Notify {
+		if item == gomail.DSNNotifyNever && len(dsn.Notify) != 1 {
+			return nil, errors.New("dsn notify invalid: never must be alone")
+		}
+	}
+
+	return dsn, nil
+}
+
+// loadKeyring reads an armored or binary OpenPGP keyring.
+func loadKeyring(name string) (openpgp.EntityList, error) {
+	buf, err := os.ReadFile(name)
+	if err != nil {
+		return nil, errors.Wrap(err, "read failed")
+	}
+
+	keyring, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(buf))
+	if err != nil {
+		if keyring, err = openpgp.ReadKeyRing(bytes.NewReader(buf)); err != nil {
+			return nil, errors.Wrap(err, "parse failed")
+		}
+	}
+
+	return keyring, nil
+}
+
+func findEntity(keyring openpgp.EntityList, address string) *openpgp.Entity {
+	for _, entity := range keyring {
+		for _, identity := range entity.Identities {
+			if identity.UserId != nil && strings.EqualFold(identity.UserId.Email, address) {
+				return entity
+			}
+		}
+	}
+
+	return nil
+}
+
+func loadCertificates(dir string) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "read failed")
+	}
+
+	for _, item := range entries {
+		if !item.Type().IsRegular() {
+			continue
+		}
+		buf, err := os.ReadFile(filepath.Join(dir, item.Name()))
+		if err != nil {
+			return nil, errors.Wrap(err, "read failed")
+		}
+		for {
+			var block *pem.Block
+			if block, buf = pem.Decode(buf); block == nil {
+				break
+			}
+			if block.Type != "CERTIFICATE" {
+				continue
+			}
+			c, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, errors.Wrapf(err, "parse failed: %s", item.Name())
+			}
+			certs = append(certs, c)
+		}
+	}
+
+	return certs, nil
+}
+
 func identifyInvalidRecipients(config *Config, data *Mail) ([]string, []string) {
 	var invalidRecipients []string
 	var validRecipients []string
@@ -1,12 +1,34 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
 	"encoding/json"
+	"encoding/pem"
 	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/mail"
+	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
 	gomail "github.com/go-mail/mail"
+
+	"github.com/craftslab/gomail/policy"
 )
 
 func TestParseConfig(t *testing.T) {
@@ -145,38 +167,1000 @@ func TestParseRecipients(t *testing.T) {
 	}
 }
 
-func TestSendMail(t *testing.T) {
-	t.Skip("Skipping integration test that would attempt real SMTP send")
+func TestCheckPolicy(t *testing.T) {
 	config, err := parseConfig("../config/sender.json")
 	if err != nil {
 		t.Error("FAIL")
 	}
 
-	// Test case 1: With header provided (header as display name, config.Sender as From address)
-	mail := Mail{
-		[]string{"../test/attach1.txt", "../test/attach2.text"},
-		"../test/body.txt",
-		[]string{"catherine@example.com"},
-		"PLAIN_TEXT",
-		"Custom Sender Name", // header option - used as display name
-		"SUBJECT",
-		[]string{"alen@example.com, bob@example.com"},
+	if err := checkPolicy(&config, []string{"alen@example.com", "bob@example.org"}); err != nil {
+		t.Error("FAIL")
 	}
 
-	_ = sendMail(&config, &mail)
+	config.Filter = []policy.Rule{
+		{Action: policy.Deny, Pattern: "bob@example.com", Reason: "bob left"},
+		{Action: policy.Allow, Pattern: "example.com"},
+	}
 
-	// Test case 2: Without header (config.Sender as From address, no display name)
-	mailNoHeader := Mail{
-		[]string{"../test/attach1.txt", "../test/attach2.text"},
-		"../test/body.txt",
-		[]string{"catherine@example.com"},
-		"PLAIN_TEXT",
-		"", // no header option - config.Sender will be used as From address without display name
-		"SUBJECT",
-		[]string{"alen@example.com, bob@example.com"},
+	if err := checkPolicy(&config, []string{"alen@example.com", "Catherine <catherine@mail.example.com>"}); err != nil {
+		t.Error("FAIL")
+	}
+
+	if err := checkPolicy(&config, []string{"alen@example.com", "bob@example.com"}); err == nil {
+		t.Error("FAIL")
+	}
+
+	if err := checkPolicy(&config, []string{"alen@example.org"}); err == nil {
+		t.Error("FAIL")
+	}
+
+	config.Filter = []policy.Rule{{Action: "block", Pattern: "example.com"}}
+
+	if err := checkPolicy(&config, []string{"alen@example.com"}); err == nil {
+		t.Error("FAIL")
+	}
+}
+
+func TestParseDKIM(t *testing.T) {
+	config, err := parseConfig("../config/sender.json")
+	if err != nil {
+		t.Error("FAIL")
+	}
+
+	if signer, err := parseDKIM(&config); err != nil || signer != nil {
+		t.Error("FAIL")
+	}
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Error("FAIL")
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Error("FAIL")
+	}
+
+	name := filepath.Join(t.TempDir(), "dkim.pem")
+	if err := os.WriteFile(name, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		t.Error("FAIL")
+	}
+
+	config.DKIM = DKIMConfig{PrivateKey: name, Selector: "mail"}
+	if signer, err := parseDKIM(&config); err != nil || signer == nil {
+		t.Error("FAIL")
+	}
+
+	config.DKIM.Canonicalization = "simple/relaxed"
+	if _, err := parseDKIM(&config); err != nil {
+		t.Error("FAIL")
+	}
+
+	config.DKIM.Canonicalization = "loose"
+	if _, err := parseDKIM(&config); err == nil {
+		t.Error("FAIL")
+	}
+
+	config.DKIM = DKIMConfig{PrivateKey: name}
+	if _, err := parseDKIM(&config); err == nil {
+		t.Error("FAIL")
+	}
+
+	config.DKIM = DKIMConfig{PrivateKey: "../test/body.txt", Selector: "mail"}
+	if _, err := parseDKIM(&config); err == nil {
+		t.Error("FAIL")
+	}
+
+	config.DKIM = DKIMConfig{PrivateKey: "dkim.pem", Selector: "mail"}
+	if _, err := parseDKIM(&config); err == nil {
+		t.Error("FAIL")
+	}
+}
+
+func TestParseSMIME(t *testing.T) {
+	config, err := parseConfig("../config/sender.json")
+	if err != nil {
+		t.Error("FAIL")
+	}
+
+	recipients := []string{"alen@example.com", "Bob <bob@example.com>"}
+
+	if smime, err := parseSMIME(&config, recipients); err != nil || smime != nil {
+		t.Error("FAIL")
+	}
+
+	dir := t.TempDir()
+	certDir := filepath.Join(dir, "certs")
+	if err := os.Mkdir(certDir, 0700); err != nil {
+		t.Error("FAIL")
+	}
+
+	certFile, keyFile := writeCertificate(t, dir, "mail@example.com")
+	writeCertificate(t, certDir, "alen@example.com")
+
+	config.SMIME = SMIMEConfig{Sign: true}
+	if _, err := parseSMIME(&config, recipients); err == nil {
+		t.Error("FAIL")
+	}
+
+	config.SMIME = SMIMEConfig{Certificate: certFile, PrivateKey: keyFile, Sign: true}
+	if smime, err := parseSMIME(&config, recipients); err != nil || smime.Certificate == nil || len(smime.Recipients) != 0 {
+		t.Error("FAIL")
+	}
+
+	config.SMIME = SMIMEConfig{Certificate: certFile, PrivateKey: "key.pem", Sign: true}
+	if _, err := parseSMIME(&config, recipients); err == nil {
+		t.Error("FAIL")
+	}
+
+	config.SMIME = SMIMEConfig{Certificate: certFile, PrivateKey: keyFile, Encrypt: true, Recipients: certDir}
+	if _, err := parseSMIME(&config, recipients); err == nil {
+		t.Error("FAIL")
+	}
+
+	writeCertificate(t, certDir, "bob@example.com")
+
+	if smime, err := parseSMIME(&config, recipients); err != nil || smime.Certificate != nil || len(smime.Recipients) != 3 {
+		t.Error("FAIL")
+	}
+
+	config.SMIME = SMIMEConfig{Encrypt: true, Recipients: certDir}
+	if smime, err := parseSMIME(&config, recipients); err != nil || len(smime.Recipients) != 2 {
+		t.Error("FAIL")
+	}
+
+	config.SMIME = SMIMEConfig{Encrypt: true, Recipients: filepath.Join(dir, "invalid")}
+	if _, err := parseSMIME(&config, recipients); err == nil {
+		t.Error("FAIL")
+	}
+}
+
+func writeCertificate(t *testing.T, dir, address string) (certFile, keyFile string) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:   big.NewInt(time.Now().UnixNano()),
+		Subject:        pkix.Name{CommonName: address},
+		EmailAddresses: []string{address},
+		NotBefore:      time.Now().Add(-time.Hour),
+		NotAfter:       time.Now().Add(time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile = filepath.Join(dir, address+".pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	keyFile = filepath.Join(dir, address+".key")
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return certFile, keyFile
+}
+
+func TestParsePGP(t *testing.T) {
+	config, err := parseConfig("../config/sender.json")
+	if err != nil {
+		t.Error("FAIL")
 	}
 
-	_ = sendMail(&config, &mailNoHeader)
+	recipients := []string{"alen@example.com", "Bob <bob@example.com>"}
+
+	if pgp, err := parsePGP(&config, recipients); err != nil || pgp != nil {
+		t.Error("FAIL")
+	}
+
+	dir := t.TempDir()
+	secretFile := filepath.Join(dir, "secring.asc")
+	publicFile := filepath.Join(dir, "pubring.asc")
+
+	sender := newEntity(t, "mail@example.com")
+	if err := sender.EncryptPrivateKeys([]byte("secret"), nil); err != nil {
+		t.Fatal(err)
+	}
+	writeKeyring(t, secretFile, true, sender)
+	writeKeyring(t, publicFile, false, newEntity(t, "alen@example.com"))
+
+	config.PGP = PGPConfig{Mode: "invalid"}
+	if _, err := parsePGP(&config, recipients); err == nil {
+		t.Error("FAIL")
+	}
+
+	config.PGP = PGPConfig{Mode: pgpSign, SecretKeyring: secretFile}
+	if _, err := parsePGP(&config, recipients); err == nil {
+		t.Error("FAIL")
+	}
+
+	config.PGP.Passphrase = "secret"
+	if pgp, err := parsePGP(&config, recipients); err != nil || pgp.Signer == nil || len(pgp.Recipients) != 0 {
+		t.Error("FAIL")
+	}
+
+	config.PGP = PGPConfig{Mode: pgpSign, SecretKeyring: publicFile}
+	if _, err := parsePGP(&config, recipients); err == nil {
+		t.Error("FAIL")
+	}
+
+	config.PGP = PGPConfig{Keyring: publicFile, Mode: pgpEncrypt}
+	if _, err := parsePGP(&config, recipients); err == nil {
+		t.Error("FAIL")
+	}
+
+	writeKeyring(t, publicFile, false, newEntity(t, "alen@example.com"), newEntity(t, "bob@example.com"))
+
+	if pgp, err := parsePGP(&config, recipients); err != nil || pgp.Signer != nil || len(pgp.Recipients) != 2 {
+		t.Error("FAIL")
+	}
+
+	config.PGP = PGPConfig{Keyring: publicFile, Mode: pgpBoth, Passphrase: "secret", SecretKeyring: secretFile}
+	if pgp, err := parsePGP(&config, recipients); err != nil || pgp.Signer == nil || len(pgp.Recipients) != 3 {
+		t.Error("FAIL")
+	}
+
+	config.PGP = PGPConfig{Keyring: filepath.Join(dir, "invalid"), Mode: pgpEncrypt}
+	if _, err := parsePGP(&config, recipients); err == nil {
+		t.Error("FAIL")
+	}
+}
+
+func newEntity(t *testing.T, address string) *openpgp.Entity {
+	entity, err := openpgp.NewEntity("", "", address, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return entity
+}
+
+func writeKeyring(t *testing.T, name string, private bool, entities ...*openpgp.Entity) {
+	var buf bytes.Buffer
+
+	blockType := openpgp.PublicKeyType
+	if private {
+		blockType = openpgp.PrivateKeyType
+	}
+
+	w, err := armor.Encode(&buf, blockType, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, item := range entities {
+		if private {
+			err = item.SerializePrivateWithoutSigning(w, nil)
+		} else {
+			err = item.Serialize(w)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(name, buf.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParseHeaders(t *testing.T) {
+	config := Config{Headers: map[string]string{"x-build-id": "41", "List-Unsubscribe": "<mailto:unsubscribe@example.com>"}}
+
+	headers, err := parseHeaders(&config, []string{"X-Build-Id: 42", "Auto-Submitted:auto-generated"}, priorityHigh)
+	if err != nil {
+		t.Fatal("FAIL")
+	}
+
+	want := map[string]string{
+		"Auto-Submitted":   "auto-generated",
+		"Importance":       "high",
+		"List-Unsubscribe": "<mailto:unsubscribe@example.com>",
+		"X-Build-Id":       "42",
+		"X-Priority":       "1 (Highest)",
+	}
+	if !reflect.DeepEqual(headers, want) {
+		t.Error("FAIL")
+	}
+
+	config.Headers = nil
+	if headers, err := parseHeaders(&config, nil, ""); err != nil || len(headers) != 0 {
+		t.Error("FAIL")
+	}
+
+	for _, item := range []string{"X-Build-Id", "X-Build-Id: 42\r\nBcc: eve@example.com", "X Build: 42", ": 42", "message-id: <a@example.com>", "FROM: eve@example.com"} {
+		if _, err := parseHeaders(&config, []string{item}, ""); err == nil {
+			t.Error("FAIL")
+		}
+	}
+
+	config.Headers = map[string]string{"Subject": "Injected"}
+	if _, err := parseHeaders(&config, nil, ""); err == nil {
+		t.Error("FAIL")
+	}
+
+	config.Sender = "mail@example.com"
+	config.Headers = want
+	data := Mail{Body: "body", ContentType: "text/plain", Subject: "Build", To: []string{"alen@example.com"}}
+
+	msg, err := buildMessage(&config, &data)
+	if err != nil {
+		t.Fatal("FAIL")
+	}
+
+	if msg.GetHeader("X-Build-Id")[0] != "42" || msg.GetHeader("X-Priority")[0] != "1 (Highest)" {
+		t.Error("FAIL")
+	}
+}
+
+func TestParseEvent(t *testing.T) {
+	if event, err := parseEvent("", "", "", "request", "", 0); err != nil || event != nil {
+		t.Error("FAIL")
+	}
+
+	event, err := parseEvent("2026-10-20T22:00:00+02:00", "", "Room 1", "request", "", 0)
+	if err != nil || event.Method != gomail.CalendarRequest || event.Location != "Room 1" {
+		t.Fatal("FAIL")
+	}
+	if !event.Start.Equal(time.Date(2026, 10, 20, 20, 0, 0, 0, time.UTC)) || event.End.Sub(event.Start) != time.Hour {
+		t.Error("FAIL")
+	}
+
+	event, err = parseEvent("2026-10-20T22:00", "2026-10-21 01:30", "", "cancel", "42@example.com", 1)
+	if err != nil || event.Method != gomail.CalendarCancel || event.UID != "42@example.com" || event.Sequence != 1 {
+		t.Fatal("FAIL")
+	}
+	if event.Start.Location() != time.Local || event.End.Sub(event.Start) != 3*time.Hour+30*time.Minute {
+		t.Error("FAIL")
+	}
+
+	for _, item := range [][]string{{"", "", "request"}, {"", "", "cancel"}, {"tomorrow", "", "request"}, {"2026-10-20T22:00", "invalid", "request"}, {"2026-10-20T22:00", "2026-10-20T21:00", "request"}, {"2026-10-20T22:00", "", "cancel"}} {
+		location := ""
+		if item[0] == "" && item[2] == "request" {
+			location = "Room 1"
+		}
+		if _, err := parseEvent(item[0], item[1], location, item[2], "", 0); err == nil {
+			t.Error("FAIL")
+		}
+	}
+
+	config := Config{Sender: "mail@example.com"}
+	data := Mail{
+		Body:        "Maintenance window",
+		Cc:          []string{"bob@example.com"},
+		ContentType: "text/plain",
+		Event:       &gomail.Event{Start: time.Now(), End: time.Now().Add(time.Hour)},
+		From:        "Ops",
+		MessageID:   "<42@example.com>",
+		Subject:     "Maintenance",
+		To:          []string{"alen@example.com"},
+	}
+
+	msg, err := buildMessage(&config, &data)
+	if err != nil {
+		t.Fatal("FAIL")
+	}
+
+	var buf bytes.Buffer
+	if _, err := msg.WriteTo(&buf); err != nil {
+		t.Fatal("FAIL")
+	}
+
+	// Undo quoted-printable soft line breaks and iCalendar folding
+	got := strings.NewReplacer("=\r\n", "", "\r\n ", "").Replace(buf.String())
+	for _, item := range []string{"text/calendar; method=REQUEST", "UID:42@example.com", "SUMMARY:Maintenance", "DESCRIPTION:Maintenance window", "mailto:mail@example.com", "mailto:alen@example.com", "OPT-PARTICIPANT"} {
+		if !strings.Contains(got, item) {
+			t.Error("FAIL")
+		}
+	}
+}
+
+func TestParseThread(t *testing.T) {
+	if inReplyTo, references, err := parseThread("", ""); err != nil || inReplyTo != "" || references != nil {
+		t.Error("FAIL")
+	}
+
+	inReplyTo, references, err := parseThread("build-2@ci.example.com", "<build-1@ci.example.com>")
+	if err != nil || inReplyTo != "<build-2@ci.example.com>" {
+		t.Error("FAIL")
+	}
+	if !reflect.DeepEqual(references, []string{"<build-1@ci.example.com>", "<build-2@ci.example.com>"}) {
+		t.Error("FAIL")
+	}
+
+	_, references, err = parseThread("<build-2@ci.example.com>", "<build-1@ci.example.com>, <build-2@ci.example.com>")
+	if err != nil || len(references) != 2 {
+		t.Error("FAIL")
+	}
+
+	for _, item := range [][]string{{"<a@example.com> <b@example.com>", ""}, {"invalid", ""}, {"", "<a@example.com> @example.com"}, {"<a@<b>", ""}} {
+		if _, _, err := parseThread(item[0], item[1]); err == nil {
+			t.Error("FAIL")
+		}
+	}
+}
+
+func TestNewMessageID(t *testing.T) {
+	config := Config{Sender: "mail@example.com"}
+
+	id, err := newMessageID(&config, "")
+	if err != nil || !strings.HasPrefix(id, "<") || !strings.HasSuffix(id, "@example.com>") {
+		t.Error("FAIL")
+	}
+
+	if other, err := newMessageID(&config, ""); err != nil || other == id {
+		t.Error("FAIL")
+	}
+
+	if other, err := newMessageID(&config, "build-1@ci.example.com"); err != nil || other != "<build-1@ci.example.com>" {
+		t.Error("FAIL")
+	}
+
+	if _, err := newMessageID(&config, "build-1"); err == nil {
+		t.Error("FAIL")
+	}
+
+	if _, err := newMessageID(&config, "<a@example.com> <b@example.com>"); err == nil {
+		t.Error("FAIL")
+	}
+
+	config.Sender = ""
+	if id, err := newMessageID(&config, ""); err != nil || !strings.HasSuffix(id, "@localhost>") {
+		t.Error("FAIL")
+	}
+
+	config.Sender = "mail@example.com"
+	data := Mail{
+		Body:        "body",
+		ContentType: "text/plain",
+		InReplyTo:   "<build-2@ci.example.com>",
+		MessageID:   id,
+		References:  []string{"<build-1@ci.example.com>", "<build-2@ci.example.com>"},
+		Subject:     "Build",
+		To:          []string{"alen@example.com"},
+	}
+
+	msg, err := buildMessage(&config, &data)
+	if err != nil {
+		t.Fatal("FAIL")
+	}
+
+	if msg.GetHeader("Message-ID")[0] != id || msg.GetHeader("In-Reply-To")[0] != "<build-2@ci.example.com>" {
+		t.Error("FAIL")
+	}
+
+	if msg.GetHeader("References")[0] != "<build-1@ci.example.com> <build-2@ci.example.com>" {
+		t.Error("FAIL")
+	}
+}
+
+func TestParseDSN(t *testing.T) {
+	config, err := parseConfig("../config/sender.json")
+	if err != nil {
+		t.Error("FAIL")
+	}
+
+	if dsn, err := parseDSN(&config); err != nil || dsn != nil {
+		t.Error("FAIL")
+	}
+
+	config.DSN = DSNConfig{EnvelopeID: "notice-42", Notify: "success, failure,delay", Return: "hdrs"}
+	dsn, err := parseDSN(&config)
+	if err != nil || dsn.EnvelopeID != "notice-42" || dsn.Return != gomail.DSNReturnHeaders || !dsn.OriginalRecipient {
+		t.Error("FAIL")
+	}
+	if !reflect.DeepEqual(dsn.Notify, []string{gomail.DSNNotifySuccess, gomail.DSNNotifyFailure, gomail.DSNNotifyDelay}) {
+		t.Error("FAIL")
+	}
+
+	config.DSN = DSNConfig{Notify: "never"}
+	if dsn, err := parseDSN(&config); err != nil || len(dsn.Notify) != 1 || dsn.Return != "" {
+		t.Error("FAIL")
+	}
+
+	for _, item := range []DSNConfig{{Return: "body"}, {Notify: "sometimes"}, {Notify: "never,failure"}} {
+		config.DSN = item
+		if _, err := parseDSN(&config); err == nil {
+			t.Error("FAIL")
+		}
+	}
+}
+
+func TestWriteMail(t *testing.T) {
+	config, err := parseConfig("../config/sender.json")
+	if err != nil {
+		t.Error("FAIL")
+	}
+
+	data := Mail{
+		[]string{"../config/sender.json"},
+		"From the team\nbody",
+		[]string{"bob@example.com"},
+		"text/plain",
+		nil,
+		"Sender",
+		"",
+		"",
+		nil,
+		nil,
+		"Title",
+		[]string{"alen@example.com"},
+	}
+
+	dir := t.TempDir()
+	name := filepath.Join(dir, "message.eml")
+
+	if err := writeMail(&config, &data, name, false); err != nil {
+		t.Error("FAIL")
+	}
+
+	buf, err := os.ReadFile(name)
+	if err != nil {
+		t.Error("FAIL")
+	}
+
+	msg, err := mail.ReadMessage(bytes.NewReader(buf))
+	if err != nil {
+		t.Error("FAIL")
+	}
+
+	if msg.Header.Get("From") != `"Sender" <mail@example.com>` || msg.Header.Get("To") != "alen@example.com" ||
+		msg.Header.Get("Cc") != "bob@example.com" || msg.Header.Get("Subject") != "Title" {
+		t.Error("FAIL")
+	}
+
+	if !strings.Contains(string(buf), `filename="sender.json"`) {
+		t.Error("FAIL")
+	}
+
+	name = filepath.Join(dir, "messages.mbox")
+
+	for i := 0; i < 2; i++ {
+		if err := writeMail(&config, &data, name, true); err != nil {
+			t.Error("FAIL")
+		}
+	}
+
+	buf, err = os.ReadFile(name)
+	if err != nil {
+		t.Error("FAIL")
+	}
+
+	if !strings.HasPrefix(string(buf), "From mail@example.com ") || strings.Count(string(buf), "\nFrom mail@example.com ") != 1 {
+		t.Error("FAIL")
+	}
+
+	if strings.Contains(string(buf), "\r\n") || !strings.Contains(string(buf), "\n>From the team") {
+		t.Error("FAIL")
+	}
+
+	if err := writeMail(&config, &data, filepath.Join(dir, "invalid", "message.eml"), false); err == nil {
+		t.Error("FAIL")
+	}
+}
+
+func TestMboxMessage(t *testing.T) {
+	date := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	msg := "Subject: test\r\n\r\nFrom here\r\n>From there\r\nFromage\r\n"
+	want := "From MAILER-DAEMON Tue Jan  2 03:04:05 2024\n" +
+		"Subject: test\n\n>From here\n>>From there\nFromage\n\n"
+
+	if got := string(mboxMessage("", date, []byte(msg))); got != want {
+		t.Errorf("FAIL: %q", got)
+	}
+}
+
+func TestSendRaw(t *testing.T) {
+	config, err := parseConfig("../config/sender.json")
+	if err != nil {
+		t.Error("FAIL")
+	}
+
+	config.Filter = []policy.Rule{
+		{Action: policy.Deny, Pattern: "bob@example.com", Reason: "bob left"},
+	}
+
+	// Nothing listens on the port, so sending fails once the policy passes
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Host = "127.0.0.1"
+	config.Port = listener.Addr().(*net.TCPAddr).Port
+	_ = listener.Close()
+
+	dir := t.TempDir()
+	name := filepath.Join(dir, "message.eml")
+
+	if err := sendRaw(context.Background(), &config, name, ""); err == nil || !strings.Contains(err.Error(), "read failed") {
+		t.Error("FAIL")
+	}
+
+	if err := os.WriteFile(name, []byte("From: mail@example.com\nTo: invalid\n\nbody\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sendRaw(context.Background(), &config, name, ""); err == nil || !strings.Contains(err.Error(), "parse failed") {
+		t.Error("FAIL")
+	}
+
+	msg := "From: mail@example.com\nTo: alen@example.com\nBcc: bob@example.com\n\nbody\n"
+	if err := os.WriteFile(name, []byte(msg), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sendRaw(context.Background(), &config, name, ""); err == nil || !strings.Contains(err.Error(), "bob left") {
+		t.Error("FAIL")
+	}
+
+	if err := sendRaw(context.Background(), &config, name, "alen@example.com"); err == nil || !strings.Contains(err.Error(), "send failed") {
+		t.Error("FAIL")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := sendRaw(ctx, &config, name, "alen@example.com"); err == nil || !strings.Contains(err.Error(), "send aborted") {
+		t.Error("FAIL")
+	}
+
+	config.Transport = transportSendmail
+	if err := sendRaw(context.Background(), &config, name, "alen@example.com"); err == nil || !strings.Contains(err.Error(), "without -t") {
+		t.Error("FAIL")
+	}
+}
+
+func TestCheckRaw(t *testing.T) {
+	config, err := parseConfig("../config/sender.json")
+	if err != nil {
+		t.Error("FAIL")
+	}
+
+	dir := t.TempDir()
+
+	config.Directory.Path = dir
+	config.Filter = []policy.Rule{
+		{Action: policy.Deny, Pattern: "bob@example.com", Reason: "bob left"},
+	}
+	config.Transport = transportDirectory
+
+	name := filepath.Join(dir, "message.eml")
+
+	msg := "From: mail@example.com\nTo: alen@example.com\nBcc: bob@example.com\n\nbody\n"
+	if err := os.WriteFile(name, []byte(msg), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := checkRaw(&config, name, ""); err == nil || !strings.Contains(err.Error(), "bob left") {
+		t.Error("FAIL")
+	}
+
+	validation, err := checkRaw(&config, name, "alen@example.com,cc:catherine@example.com")
+	if err != nil || validation.ValidCount != 2 || validation.InvalidCount != 0 {
+		t.Error("FAIL")
+	}
+
+	// Nothing is delivered
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Error("FAIL")
+	}
+}
+
+func TestWriteRaw(t *testing.T) {
+	config, err := parseConfig("../config/sender.json")
+	if err != nil {
+		t.Error("FAIL")
+	}
+
+	config.Filter = []policy.Rule{
+		{Action: policy.Deny, Pattern: "bob@example.com", Reason: "bob left"},
+	}
+
+	dir := t.TempDir()
+	name := filepath.Join(dir, "message.eml")
+	output := filepath.Join(dir, "output.eml")
+
+	msg := "From: mail@example.com\r\nTo: alen@example.com\r\nBcc: catherine@example.com\r\n\r\nbody\r\n"
+	if err := os.WriteFile(name, []byte(msg), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writeRaw(&config, name, "bob@example.com", output, false); err == nil || !strings.Contains(err.Error(), "bob left") {
+		t.Error("FAIL")
+	}
+
+	if err := writeRaw(&config, name, "", output, false); err != nil {
+		t.Error("FAIL")
+	}
+
+	buf, err := os.ReadFile(output)
+	if err != nil || string(buf) != "From: mail@example.com\r\nTo: alen@example.com\r\n\r\nbody\r\n" {
+		t.Error("FAIL")
+	}
+
+	output = filepath.Join(dir, "messages.mbox")
+
+	for i := 0; i < 2; i++ {
+		if err := writeRaw(&config, name, "", output, true); err != nil {
+			t.Error("FAIL")
+		}
+	}
+
+	buf, err = os.ReadFile(output)
+	if err != nil || strings.Count(string(buf), "From mail@example.com ") != 2 || strings.Contains(string(buf), "\r") {
+		t.Error("FAIL")
+	}
+}
+
+func TestNewSender(t *testing.T) {
+	config, err := parseConfig("../config/sender.json")
+	if err != nil {
+		t.Error("FAIL")
+	}
+
+	dir := t.TempDir()
+
+	config.Transport = transportDirectory
+	if _, err := newSender(context.Background(), &config); err == nil {
+		t.Error("FAIL")
+	}
+
+	config.Directory.Path = dir
+	config.Directory.Envelope = true
+	if _, err := newSender(context.Background(), &config); err != nil {
+		t.Error("FAIL")
+	}
+
+	if !smtpRecipientExists(&config, "alen@example.com") {
+		t.Error("FAIL")
+	}
+
+	mail := Mail{
+		nil,
+		"body",
+		[]string{"catherine@example.com"},
+		"PLAIN_TEXT",
+		nil,
+		"",
+		"",
+		"",
+		nil,
+		nil,
+		"SUBJECT",
+		[]string{"alen@example.com"},
+	}
+
+	if err := sendMail(context.Background(), &config, &mail); err != nil {
+		t.Error("FAIL")
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if err != nil || len(files) != 1 {
+		t.Fatal("FAIL")
+	}
+
+	buf, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(string(buf), "X-Sender: <"+config.Sender+">\r\nX-Receiver: <catherine@example.com>\r\nX-Receiver: <alen@example.com>\r\n") &&
+		!strings.HasPrefix(string(buf), "X-Sender: <"+config.Sender+">\r\nX-Receiver: <alen@example.com>\r\nX-Receiver: <catherine@example.com>\r\n") {
+		t.Error("FAIL")
+	}
+
+	config.Transport = transportSendmail
+	if _, err := newSender(context.Background(), &config); err != nil {
+		t.Error("FAIL")
+	}
+
+	config.Transport = transportLMTP
+	if _, err := newSender(context.Background(), &config); err == nil {
+		t.Error("FAIL")
+	}
+
+	config.LMTP.Network = "unix"
+	config.LMTP.Address = filepath.Join(dir, "lmtp")
+	if _, err := newSender(context.Background(), &config); err == nil || !strings.Contains(err.Error(), "send failed") {
+		t.Error("FAIL")
+	}
+
+	config.Transport = transportHTTP
+	if _, err := newSender(context.Background(), &config); err == nil {
+		t.Error("FAIL")
+	}
+
+	config.Transport = "invalid"
+	if _, err := newSender(context.Background(), &config); err == nil {
+		t.Error("FAIL")
+	}
+}
+
+func TestSendMailHTTP(t *testing.T) {
+	config, err := parseConfig("../config/sender.json")
+	if err != nil {
+		t.Error("FAIL")
+	}
+
+	var body map[string]interface{}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		if body["from"] == "rejected@example.com" {
+			http.Error(w, "550 user unknown", http.StatusBadRequest)
+		}
+	}))
+	defer ts.Close()
+
+	config.Transport = transportHTTP
+	config.HTTP.URL = ts.URL
+	config.HTTP.Header = map[string]string{"Authorization": "Bearer token"}
+
+	mail := Mail{
+		nil,
+		"body",
+		nil,
+		"PLAIN_TEXT",
+		nil,
+		"",
+		"",
+		"",
+		nil,
+		nil,
+		"SUBJECT",
+		[]string{"alen@example.com"},
+	}
+
+	if err := sendMail(context.Background(), &config, &mail); err != nil {
+		t.Error("FAIL")
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(body["raw"].(string))
+	if err != nil || body["from"] != config.Sender || !strings.Contains(string(raw), "Subject: SUBJECT") {
+		t.Error("FAIL")
+	}
+
+	config.HTTP.Header = nil
+	if err := sendMail(context.Background(), &config, &mail); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Error("FAIL")
+	}
+
+	// Recipients are only probed over SMTP.
+	config.HTTP.Header = map[string]string{"Authorization": "Bearer token"}
+	config.Sender = "rejected@example.com"
+	if err := sendMail(context.Background(), &config, &mail); err == nil ||
+		!strings.Contains(err.Error(), "550 user unknown") || strings.Contains(err.Error(), "invalid recipient") {
+		t.Error("FAIL")
+	}
+}
+
+func TestSendMail(t *testing.T) {
+	t.Skip("Skipping integration test that would attempt real SMTP send")
+	config, err := parseConfig("../config/sender.json")
+	if err != nil {
+		t.Error("FAIL")
+	}
+
+	// Test case 1: With header provided (header as display name, config.Sender as From address)
+	mail := Mail{
+		[]string{"../test/attach1.txt", "../test/attach2.text"},
+		"../test/body.txt",
+		[]string{"catherine@example.com"},
+		"PLAIN_TEXT",
+		nil,
+		"Custom Sender Name", // header option - used as display name
+		"",
+		"",
+		nil,
+		nil,
+		"SUBJECT",
+		[]string{"alen@example.com, bob@example.com"},
+	}
+
+	_ = sendMail(context.Background(), &config, &mail)
+
+	// Test case 2: Without header (config.Sender as From address, no display name)
+	mailNoHeader := Mail{
+		[]string{"../test/attach1.txt", "../test/attach2.text"},
+		"../test/body.txt",
+		[]string{"catherine@example.com"},
+		"PLAIN_TEXT",
+		nil,
+		"", // no header option - config.Sender will be used as From address without display name
+		"",
+		"",
+		nil,
+		nil,
+		"SUBJECT",
+		[]string{"alen@example.com, bob@example.com"},
+	}
+
+	_ = sendMail(context.Background(), &config, &mailNoHeader)
+}
+
+func TestParseBounces(t *testing.T) {
+	dir := t.TempDir()
+
+	report := `From: MAILER-DAEMON@example.com
+Subject: Undelivered Mail Returned to Sender
+MIME-Version: 1.0
+Content-Type: multipart/report; report-type=delivery-status; boundary="B"
+
+--B
+Content-Type: message/delivery-status
+
+Reporting-MTA: dns; mx.example.com
+
+Final-Recipient: rfc822; alen@example.com
+Action: failed
+Status: 5.1.1
+Diagnostic-Code: smtp; 550 5.1.1 User unknown
+
+Final-Recipient: rfc822; bob@example.com
+Action: delivered
+Status: 2.0.0
+
+--B
+Content-Type: text/rfc822-headers
+
+Message-ID: <123@example.com>
+
+--B--
+`
+
+	name := filepath.Join(dir, "bounce.eml")
+	if err := os.WriteFile(name, []byte(report), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	bounces, err := parseBounces([]string{name}, "")
+	if err != nil || len(bounces) != 1 {
+		t.Fatal("FAIL")
+	}
+
+	if bounces[0].Recipient != "alen@example.com" || bounces[0].Status != "5.1.1" || bounces[0].MessageID != "<123@example.com>" {
+		t.Error("FAIL")
+	}
+
+	sent := filepath.Join(dir, "sent.txt")
+	if err := os.WriteFile(sent, []byte("<456@example.com>\n<123@example.com>\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if bounces, err := parseBounces([]string{name}, sent); err != nil || len(bounces) != 1 {
+		t.Error("FAIL")
+	}
+
+	if err := os.WriteFile(sent, []byte("<456@example.com>\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if bounces, err := parseBounces([]string{name}, sent); err != nil || len(bounces) != 0 {
+		t.Error("FAIL")
+	}
+
+	if _, err := parseBounces([]string{name}, filepath.Join(dir, "invalid")); err == nil {
+		t.Error("FAIL")
+	}
+
+	if _, err := parseBounces([]string{filepath.Join(dir, "invalid")}, ""); err == nil {
+		t.Error("FAIL")
+	}
 }
 
 func TestCheckFile(t *testing.T) {